@@ -4,14 +4,28 @@ import (
 	"bufio"
 	"compress/zlib"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
-	"strconv"
-	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
+// The first byte of a zlib stream using the deflate compression method.
+const zlibMagic = 0x78
+
+// The largest distance from the offset stated in the header at which the file tree is searched.
+const maxFileTreeDrift = 8
+
+// Returned by NewArchive in strict mode if the parsed archive index looks like garbage.
+var ErrObfuscated = errors.New("archive index looks invalid, the archive may use a custom obfuscation scheme")
+
+// The largest amount of padding bytes expected between two consecutive files in an archive.
+const maxPadding = 4096
+
 // Represents an file index in an specific RPA archive.
 // It contains meta information about the file e.g. the relative file name, the offset in the archive and the length in bytes.
 type ArchiveIndex struct {
@@ -21,18 +35,69 @@ type ArchiveIndex struct {
 	Prefix []byte
 }
 
+// Contains the location of a file, which is used to look up indices by their file path.
+type indexLocation struct {
+	offset int64
+	length int
+}
+
 // Represents a whole RPA archive including all its indices.
 type Archive struct {
 	FileName string
 	Version int
+	// Contains the trailing tokens of the header which are not part of the key, e.g. a build comment.
+	// Hexadecimal words at the beginning of a comment are treated as key tokens and are not included.
+	Comment string
 	Indices []ArchiveIndex
-	handle *os.File
+	// Contains the errors of malformed indices which were skipped while parsing the archive.
+	Errors []error
+	path string
+	handle io.ReaderAt
+	closer io.Closer
+	size int64
+	lookup map[string][]indexLocation
+	settings archiveOptions
 }
 
 // Returns a value indicating whether the archive is supported and valid.
-// The function performs a simple version check for an RPA-2.0 and an RPA-3.0 archive.
+// The function performs a simple version check for an archive with a separate index, an RPA-2.0 and an RPA-3.0 archive.
 func (archive Archive) IsValid() bool {
-	return archive.Version == 2 || archive.Version == 3
+	return archive.Version == 1 || archive.Version == 2 || archive.Version == 3
+}
+
+// Returns a value indicating whether the parsed indices look like garbage.
+// This is usually the case if the archive uses a custom obfuscation or encryption scheme on top of the standard RPA format.
+// The check is a heuristic: the indices look obfuscated if most file paths contain non-printable characters
+// or if most files are located outside of the archive.
+func (archive *Archive) LooksObfuscated() bool {
+	if len(archive.Indices) == 0 {
+		return false
+	}
+
+	unprintable, outOfRange := 0, 0
+	for _, v := range archive.Indices {
+		if !isPrintablePath(v.FilePath) {
+			unprintable++
+		}
+		end := v.Offset + int64(v.Length - len(v.Prefix))
+		if v.Offset < 0 || v.Length < len(v.Prefix) || (archive.size > 0 && end > archive.size) {
+			outOfRange++
+		}
+	}
+	return unprintable * 2 > len(archive.Indices) || outOfRange * 2 > len(archive.Indices)
+}
+
+// Returns a value indicating whether the file path is valid UTF-8 and consists of printable characters only.
+func isPrintablePath(path string) bool {
+	if path == "" || !utf8.ValidString(path) {
+		return false
+	}
+	for _, r := range path {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
 }
 
 // Checks whether the specified archive index is located within the archive.
@@ -51,6 +116,32 @@ func (archive Archive) ContainsIndex(index *ArchiveIndex) bool {
 	return false
 }
 
+// Checks whether the specified archive index is located within the archive.
+// In contrast to ContainsIndex the index is looked up by its file path in a table, which is built when the archive is parsed.
+// The table stores the locations of the files instead of their positions in Indices, so it stays valid if the indices
+// are reordered. It is never modified afterwards, which keeps concurrent reads safe. Indices which are not found in the
+// table, e.g. because they were added after parsing, are compared one by one.
+func (archive *Archive) hasIndex(index *ArchiveIndex) bool {
+	if index == nil {
+		return false
+	}
+
+	for _, v := range archive.lookup[index.FilePath] {
+		if v.offset == index.Offset && v.length == index.Length {
+			return true
+		}
+	}
+	return archive.ContainsIndex(index)
+}
+
+// Builds the table used by hasIndex to look up the locations of the files by their path.
+func (archive *Archive) buildLookup() {
+	archive.lookup = make(map[string][]indexLocation, len(archive.Indices))
+	for _, v := range archive.Indices {
+		archive.lookup[v.FilePath] = append(archive.lookup[v.FilePath], indexLocation{v.Offset, v.Length})
+	}
+}
+
 // Returns an array of the relative paths of all files located within the archive.
 // The list is sorted alphabetically by the function.
 func (archive *Archive) GetFiles() ([]string, error) {
@@ -69,22 +160,76 @@ func (archive *Archive) GetFiles() ([]string, error) {
 	return list, nil
 }
 
+// Calls the specified function with the relative path of every file located within the archive.
+// In contrast to GetFiles the paths are passed in the order of the archive indices and no list of paths is allocated.
+func (archive *Archive) WalkFiles(fn func(path string)) error {
+	if !archive.IsValid() {
+		return errors.New("invalid archive version")
+	}
+
+	for i := range archive.Indices {
+		fn(archive.Indices[i].FilePath)
+	}
+	return nil
+}
+
+// Returns a copy of the archive indices sorted by their offset in the archive.
+func (archive *Archive) indicesByOffset() []ArchiveIndex {
+	sorted := make([]ArchiveIndex, len(archive.Indices))
+	copy(sorted, archive.Indices)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Offset < sorted[j].Offset
+	})
+	return sorted
+}
+
+// Returns the amount of gaps between consecutive file bodies which are larger than the specified amount of bytes.
+// Ren'Py only inserts a few bytes of padding between files, so large gaps suggest an unusual padding scheme.
+func (archive *Archive) countLargeGaps(threshold int64) int {
+	sorted := archive.indicesByOffset()
+	count := 0
+	for i := 1; i < len(sorted); i++ {
+		// The prefix is stored in the index and is not part of the body on disk.
+		previous := sorted[i - 1]
+		end := previous.Offset + int64(previous.Length - len(previous.Prefix))
+		if sorted[i].Offset - end > threshold {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns all pairs of files whose bodies overlap in the archive.
+// The range of a file body is [Offset, Offset+Length) without the prefix, which is stored in the index.
+// Overlapping files usually indicate a parse error or a corrupt index. Every pair is ordered by offset.
+func (archive *Archive) Overlaps() [][2]ArchiveIndex {
+	sorted := archive.indicesByOffset()
+	var overlaps [][2]ArchiveIndex
+	for i, a := range sorted {
+		end := a.Offset + int64(a.Length - len(a.Prefix))
+		for j := i + 1; j < len(sorted) && sorted[j].Offset < end; j++ {
+			// Files without a body never overlap.
+			if sorted[j].Length - len(sorted[j].Prefix) > 0 {
+				overlaps = append(overlaps, [2]ArchiveIndex{a, sorted[j]})
+			}
+		}
+	}
+	return overlaps
+}
+
 // Reads the specified file from the archive.
 // If the file handle of the archive was not opened at the time of the call, the file will be opened in read-only mode.
 // If successful the function will return the file contents of the specified file.
 func (archive *Archive) Read(index *ArchiveIndex) ([]byte, error) {
 	// Check if file exists and is loaded.
-	if index == nil || !archive.ContainsIndex(index) {
+	if !archive.hasIndex(index) {
 		return nil, errors.New("index cannot be nil and must be valid")
 	}
 
 	// Open archive file in read-only mode.
-	if archive.handle == nil {
-		handle, err := os.Open(archive.FileName)
-		if err != nil {
-			return nil, err
-		}
-		archive.handle = handle
+	err := archive.open()
+	if err != nil {
+		return nil, err
 	}
 
 	// Read amount of bytes from the file offset.
@@ -98,26 +243,160 @@ func (archive *Archive) Read(index *ArchiveIndex) ([]byte, error) {
 	return append(index.Prefix, data[:bytesRead]...), nil
 }
 
+// Reads n bytes starting at the offset off within the specified file from the archive.
+// The offset is relative to the start of the file contents including the prefix stored in the index.
+// A range which starts in the prefix and ends in the body on disk is combined into a single slice.
+func (archive *Archive) ReadRange(index *ArchiveIndex, off, n int64) ([]byte, error) {
+	// Check if file exists and is loaded.
+	if !archive.hasIndex(index) {
+		return nil, errors.New("index cannot be nil and must be valid")
+	}
+
+	// Check if range is located within the file.
+	if off < 0 || n < 0 || off > int64(index.Length) || n > int64(index.Length) - off {
+		return nil, errors.New("range is out of bounds")
+	}
+
+	// Copy the part of the range which is located in the prefix.
+	data := make([]byte, 0, n)
+	prefixLength := int64(len(index.Prefix))
+	if off < prefixLength {
+		end := off + n
+		if end > prefixLength {
+			end = prefixLength
+		}
+		data = append(data, index.Prefix[off:end]...)
+	}
+
+	// Read the remaining part of the range from the body on disk.
+	remaining := n - int64(len(data))
+	if remaining == 0 {
+		return data, nil
+	}
+	err := archive.open()
+	if err != nil {
+		return nil, err
+	}
+	bodyOffset := off + int64(len(data)) - prefixLength
+	body := make([]byte, remaining)
+	_, err = archive.handle.ReadAt(body, index.Offset + bodyOffset)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, body...), nil
+}
+
+// Writes the contents of the specified file from the archive to the writer.
+// In contrast to Read, the body of the file is streamed from the archive instead of being loaded into memory.
+// Returns the amount of bytes written.
+func (archive *Archive) WriteIndexTo(writer io.Writer, index *ArchiveIndex) (int64, error) {
+	// Check if file exists and is loaded.
+	if !archive.hasIndex(index) {
+		return 0, errors.New("index cannot be nil and must be valid")
+	}
+
+	// Open archive file in read-only mode.
+	err := archive.open()
+	if err != nil {
+		return 0, err
+	}
+
+	// Write the prefix stored in the index.
+	written, err := writer.Write(index.Prefix)
+	if err != nil {
+		return int64(written), err
+	}
+
+	// Stream the body from the file offset.
+	length := int64(index.Length - len(index.Prefix))
+	copied, err := io.Copy(writer, io.NewSectionReader(archive.handle, index.Offset, length))
+	total := int64(written) + copied
+	if err != nil {
+		return total, err
+	} else if copied != length {
+		return total, io.ErrUnexpectedEOF
+	}
+	return total, nil
+}
+
+// Opens the file handle of the archive in read-only mode if it is not already open.
+func (archive *Archive) open() error {
+	if archive.handle == nil {
+		if archive.path == "" {
+			return errors.New("archive cannot be reopened")
+		}
+		handle, err := os.Open(archive.path)
+		if err != nil {
+			return err
+		}
+		archive.handle = archive.settings.wrapReader(handle)
+		archive.closer = handle
+	}
+	return nil
+}
+
 // Closes the open file handle of the archive.
 // If the file handle was closed at the time of the call, nil will be returned.
 func (archive *Archive) Close() error {
-	if archive.handle != nil {
-		return archive.handle.Close()
+	if archive.closer != nil {
+		return archive.closer.Close()
 	}
 	return nil
 }
 
+// Decompresses the zlib compressed file tree located at the specified offset.
+// If the file tree cannot be decompressed at the exact offset, the nearby offsets starting with the zlib magic byte are tried.
+func readFileTree(reader io.ReaderAt, size int64, offset int64) ([]byte, error) {
+	uncompressed, err := decompress(reader, size, offset)
+	if err == nil {
+		return uncompressed, nil
+	}
+
+	// Scan the nearby offsets for the beginning of a zlib stream.
+	magic := make([]byte, 1)
+	for distance := int64(1); distance <= maxFileTreeDrift; distance++ {
+		for _, candidate := range []int64{offset - distance, offset + distance} {
+			if candidate < 0 || candidate >= size {
+				continue
+			}
+			if _, err := reader.ReadAt(magic, candidate); err != nil || magic[0] != zlibMagic {
+				continue
+			}
+
+			corrected, err := decompress(reader, size, candidate)
+			if err == nil {
+				fmt.Fprintf(os.Stdout, "(Warning) File tree found at offset 0x%x instead of 0x%x.\n", candidate, offset)
+				return corrected, nil
+			}
+		}
+	}
+	return nil, err
+}
+
+// Decompresses the zlib stream starting at the specified offset.
+func decompress(reader io.ReaderAt, size int64, offset int64) ([]byte, error) {
+	if offset < 0 || offset >= size {
+		return nil, errors.New("file tree offset is out of range")
+	}
+
+	stream, err := zlib.NewReader(io.NewSectionReader(reader, offset, size - offset))
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	return ioutil.ReadAll(stream)
+}
+
 // Creates a new representation of an RPA archive from the specified file.
 // Returns the pointer to the newly allocated instance.
-func NewArchive(path string) (*Archive, error) {
+func NewArchive(path string, options ...ArchiveOption) (*Archive, error) {
+	settings := applyOptions(options)
+
 	// Check if file exists and get file information.
-	stat, err := os.Stat(path)
-	if os.IsNotExist(err) {
+	stat, err := statArchive(path)
+	if err != nil {
 		return nil, err
 	}
-	if stat.IsDir() {
-		return nil, errors.New("archive is not a file")
-	}
 
 	// Check if file is long enough.
 	if stat.Size() < 51 {
@@ -130,72 +409,227 @@ func NewArchive(path string) (*Archive, error) {
 		return nil, err
 	}
 
-	// Determine archive version.
-	reader := bufio.NewReader(file)
-	header, err := reader.ReadString('\n')
+	archive, err := parseArchive(settings.wrapReader(file), stat.Size(), settings)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
+	archive.FileName = filepath.Base(path)
+	archive.path = path
+	archive.closer = file
+	return archive, nil
+}
 
-	var version int
-	if strings.HasPrefix(header, "RPA-2.0") {
-		version = 2
-	} else if strings.HasPrefix(header, "RPA-3.0") {
-		version = 3
-	} else {
-		return nil, errors.New("invalid archive version")
+// Creates a new representation of an RPA archive which is read from the specified reader.
+// The size is the total amount of bytes of the archive available from the reader.
+// Closing the archive does not close the reader; if it implements io.Closer, it must be closed by the caller.
+// Returns the pointer to the newly allocated instance.
+func NewArchiveFromReaderAt(reader io.ReaderAt, size int64, options ...ArchiveOption) (*Archive, error) {
+	settings := applyOptions(options)
+
+	// Check if archive is long enough.
+	if size < 51 {
+		return nil, errors.New("file size is invalid")
 	}
+	return parseArchive(settings.wrapReader(reader), size, settings)
+}
 
-	// Parse offset of file tree.
-	splitted := strings.Split(header, "\x20")
-	if len(splitted) < 2 {
-		return nil, errors.New("invalid header")
+// Parses the header and the file tree of the archive which is read from the specified reader.
+func parseArchive(source io.ReaderAt, size int64, settings archiveOptions) (*Archive, error) {
+	parsed, uncompressed, err := readArchiveTree(source, size, settings)
+	if err != nil {
+		return nil, err
 	}
-	tmp := splitted[1][:len(splitted[1])]
-	offset, err := strconv.ParseInt(tmp, 16, 64)
+
+	// Unpickle the file tree and parse file indices.
+	indices, errs, err := unpickleIndices(uncompressed, settings)
 	if err != nil {
 		return nil, err
 	}
 
-	// Seek to file tree of archive.
-	file.Seek(offset, 0)
-	stream, err := zlib.NewReader(file)
+	// Apply deobfuscation of offset and length if necessary.
+	for i := range indices {
+		parsed.deobfuscate(&indices[i], settings)
+	}
+
+	// Create instance of archive structure.
+	archive := &Archive{Version: parsed.version, Comment: parsed.comment, Indices: indices, Errors: errs, handle: source, size: size, settings: settings}
+	archive.buildLookup()
+	err = archive.validate(settings)
 	if err != nil {
 		return nil, err
 	}
+	return archive, nil
+}
+
+// Reads the header and the decompressed file tree of the archive which is read from the specified reader.
+func readArchiveTree(source io.ReaderAt, size int64, settings archiveOptions) (archiveHeader, []byte, error) {
+	// Read header line of archive.
+	reader := bufio.NewReader(io.NewSectionReader(source, 0, size))
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return archiveHeader{}, nil, err
+	}
+
+	// Parse version, offset of file tree and deobfuscation key.
+	parsed, err := parseHeader(header, settings.strict)
+	if err != nil {
+		return archiveHeader{}, nil, err
+	}
 
 	// Decompress file tree using zlib.
-	uncompressed, err := ioutil.ReadAll(stream)
+	uncompressed, err := readFileTree(source, size, parsed.offset)
+	if err != nil {
+		return archiveHeader{}, nil, err
+	}
+	return parsed, uncompressed, nil
+}
+
+// Calls the specified function with every index of the RPA archive at the specified path in the order of the file tree.
+// In contrast to NewArchive the indices are passed while the file tree is parsed and are not kept in memory,
+// which allows listing archives with a very large number of files. The indices are not validated.
+// Walking stops at the first error returned by fn. With WithBestEffort malformed indices are skipped and their errors are returned.
+func WalkArchive(path string, fn func(ArchiveIndex) error, options ...ArchiveOption) ([]error, error) {
+	settings := applyOptions(options)
+
+	// Check if file exists and is long enough.
+	stat, err := statArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < 51 {
+		return nil, errors.New("file size is invalid")
+	}
+
+	// Try to open archive in read-only mode.
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	parsed, uncompressed, err := readArchiveTree(settings.wrapReader(file), stat.Size(), settings)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.version != 2 && parsed.version != 3 {
+		return nil, errors.New("invalid archive version")
+	}
+
+	return UnpickleFunc(uncompressed, settings.bestEffort, func(index ArchiveIndex) error {
+		parsed.deobfuscate(&index, settings)
+		return fn(index)
+	})
+}
+
+// Creates a new representation of an RPA archive whose file tree is stored in a separate index file.
+// The index file contains the zlib compressed file tree without a header, like the index of an RPA-1.0 archive.
+// The offsets in the file tree are relative to the beginning of the data file, from which all file contents are read.
+// Returns the pointer to the newly allocated instance.
+func NewArchiveWithIndex(dataPath, indexPath string, options ...ArchiveOption) (*Archive, error) {
+	settings := applyOptions(options)
+
+	// Check if both files exist and get file information.
+	dataStat, err := statArchive(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	indexStat, err := statArchive(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decompress file tree from the index file using zlib.
+	indexFile, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	uncompressed, err := readFileTree(indexFile, indexStat.Size(), 0)
+	indexFile.Close()
 	if err != nil {
 		return nil, err
 	}
 
 	// Unpickle the file tree and parse file indices.
-	indices, err := Unpickle(uncompressed)
+	indices, errs, err := unpickleIndices(uncompressed, settings)
 	if err != nil {
 		return nil, err
 	}
 
-	// Apply deobfuscation of offset and length if necessary.
-	if version == 3 {
-		// Calculate deobfuscation key.
-		key := 0
-		for _, v := range splitted[2:] {
-			parsed, err := strconv.ParseInt(v[:len(v) - 1], 16, 32)
-			if err != nil {
-				return nil, err
-			}
-			key ^= int(parsed)
+	// Try to open data file in read-only mode.
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create instance of archive structure.
+	archive := &Archive{FileName: filepath.Base(dataPath), Version: 1, Indices: indices, Errors: errs, path: dataPath, handle: settings.wrapReader(file), closer: file, size: dataStat.Size(), settings: settings}
+	archive.buildLookup()
+	err = archive.validate(settings)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return archive, nil
+}
+
+// Applies the specified options to the default settings.
+func applyOptions(options []ArchiveOption) archiveOptions {
+	var settings archiveOptions
+	for _, option := range options {
+		option(&settings)
+	}
+	return settings
+}
+
+// Returns the file information of the specified archive file.
+func statArchive(path string) (os.FileInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		return nil, errors.New("archive is not a file")
+	}
+	return stat, nil
+}
+
+// Unpickles the file tree and parses the file indices.
+// In best-effort mode malformed indices are skipped and their errors returned.
+func unpickleIndices(uncompressed []byte, settings archiveOptions) ([]ArchiveIndex, []error, error) {
+	if settings.bestEffort {
+		return UnpickleBestEffort(uncompressed)
+	}
+	indices, err := Unpickle(uncompressed)
+	return indices, nil, err
+}
+
+// Checks the parsed indices of the archive for common problems and prints warnings about them.
+// In strict mode an error is returned if the indices look like garbage.
+func (archive *Archive) validate(settings archiveOptions) error {
+	// Check whether the archive index was parsed into garbage.
+	if archive.LooksObfuscated() {
+		if settings.strict {
+			return ErrObfuscated
 		}
+		fmt.Fprintf(os.Stdout, "(Warning) The archive index looks invalid, the archive may use a custom obfuscation scheme.\n")
+		return nil
+	}
 
-		// Apply deobfuscation.
-		for i, v := range indices {
-			v.Offset = v.Offset ^ int64(key)
-			v.Length = v.Length ^ key
-			indices[i] = v
+	// Warn about files which are located outside of the archive.
+	outOfRange := 0
+	for _, v := range archive.Indices {
+		if v.Offset < 0 || v.Offset + int64(v.Length - len(v.Prefix)) > archive.size {
+			outOfRange++
 		}
 	}
+	if outOfRange > 0 {
+		fmt.Fprintf(os.Stdout, "(Warning) Found %d file(s) located outside of the archive.\n", outOfRange)
+	}
 
-	// Create instance of archive structure.
-	return &Archive{filepath.Base(path),version, indices, file}, nil
-}
\ No newline at end of file
+	// Warn about gaps between files which exceed the usual padding.
+	if gaps := archive.countLargeGaps(maxPadding); gaps > 0 {
+		fmt.Fprintf(os.Stdout, "(Warning) Found %d gap(s) larger than %d bytes between files, the archive may use an unusual padding scheme.\n", gaps, maxPadding)
+	}
+	return nil
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// Opens an archive containing a single file whose first bytes are stored as prefix.
+func openPrefixArchive(t *testing.T, data []byte, prefixLength int) (*Archive, ArchiveIndex) {
+	t.Helper()
+	files := []WriterFile{{FilePath: "video.webm", Data: data, PrefixLength: prefixLength}, {FilePath: "other.txt", Data: []byte("other file")}}
+	archive, err := NewArchive(writeTestArchive(t, files, WithPadding(1, 8)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { archive.Close() })
+	return archive, archive.Indices[0]
+}
+
+func TestReadRange(t *testing.T) {
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	archive, index := openPrefixArchive(t, data, 10)
+
+	cases := []struct {
+		name string
+		off int64
+		n int64
+	}{
+		{"prefix", 2, 5},
+		{"whole prefix", 0, 10},
+		{"body", 15, 10},
+		{"body end", 30, 6},
+		{"spanning", 7, 10},
+		{"spanning boundary exactly", 9, 2},
+		{"whole file", 0, int64(len(data))},
+		{"empty", 10, 0},
+		{"empty at end", int64(len(data)), 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := archive.ReadRange(&index, c.off, c.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data[c.off:c.off + c.n]) {
+				t.Fatalf("expected %q, got %q", data[c.off:c.off + c.n], got)
+			}
+		})
+	}
+}
+
+func TestReadRangeWithoutPrefix(t *testing.T) {
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	archive, index := openPrefixArchive(t, data, 0)
+	got, err := archive.ReadRange(&index, 5, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[5:25]) {
+		t.Fatalf("expected %q, got %q", data[5:25], got)
+	}
+}
+
+func TestReadRangeOutOfBounds(t *testing.T) {
+	archive, index := openPrefixArchive(t, []byte("0123456789abcdefghijklmnopqrstuvwxyz"), 10)
+	for _, r := range [][2]int64{{-1, 5}, {0, -1}, {30, 7}, {37, 0}, {1, math.MaxInt64}, {math.MaxInt64, 1}} {
+		if _, err := archive.ReadRange(&index, r[0], r[1]); err == nil {
+			t.Fatalf("expected error for range %v", r)
+		}
+	}
+}
+
+func TestWithKeySalt(t *testing.T) {
+	const key, salt = 0x42424242, 0x1337
+
+	// Obfuscate the indices with the salted key but store the unsalted key in the header.
+	files := testFiles()
+	path := writeTestArchive(t, files, WithWriterKey(key ^ salt))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data[25:33], fmt.Sprintf("%08x", key))
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := NewArchive(path, WithKeySalt(salt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	assertArchiveContents(t, archive, files)
+
+	// Without the salt or with a different salt every offset is decoded incorrectly.
+	for _, options := range [][]ArchiveOption{nil, {WithKeySalt(0x1338)}} {
+		other, err := NewArchive(path, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range other.Indices {
+			if v.Offset == archive.Indices[i].Offset || v.Length == archive.Indices[i].Length {
+				t.Fatalf("%s: decoded without the matching salt", v.FilePath)
+			}
+			if data, err := other.Read(&v); err == nil && bytes.Equal(data, files[i].Data) {
+				t.Fatalf("%s: read without the matching salt", v.FilePath)
+			}
+		}
+		other.Close()
+	}
+}
+
+// Writes an archive whose header records the file tree at the specified distance from its real offset.
+func writeMisplacedTreeArchive(t *testing.T, files []WriterFile, distance int64) string {
+	t.Helper()
+	path := writeTestArchive(t, files, WithWriterKey(0x1234))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, err := strconv.ParseInt(string(data[8:24]), 16, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data[8:24], fmt.Sprintf("%016x", offset + distance))
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewArchiveMisplacedFileTree(t *testing.T) {
+	files := testFiles()
+	for _, distance := range []int64{1, -1, maxFileTreeDrift, -maxFileTreeDrift} {
+		archive, err := NewArchive(writeMisplacedTreeArchive(t, files, distance))
+		if err != nil {
+			t.Fatalf("distance %d: %v", distance, err)
+		}
+		assertArchiveContents(t, archive, files)
+		archive.Close()
+	}
+
+	// File trees further away are not searched.
+	_, err := NewArchive(writeMisplacedTreeArchive(t, files, 64))
+	if err == nil {
+		t.Fatal("expected error for file tree outside of the scanned range")
+	}
+}
+
+func TestWalkArchive(t *testing.T) {
+	files := testFiles()
+	path := writeTestArchive(t, files, WithPadding(0, 16))
+	archive, err := NewArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	var walked []ArchiveIndex
+	errs, err := WalkArchive(path, func(index ArchiveIndex) error {
+		walked = append(walked, index)
+		return nil
+	})
+	if err != nil || len(errs) > 0 {
+		t.Fatal(err, errs)
+	}
+	if !reflect.DeepEqual(walked, archive.Indices) {
+		t.Fatalf("expected %v, got %v", archive.Indices, walked)
+	}
+
+	// Walking stops at the first error of the function.
+	stop := errors.New("stop")
+	count := 0
+	_, err = WalkArchive(path, func(index ArchiveIndex) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Fatalf("expected walk to stop after the first index, got %v after %d indices", err, count)
+	}
+}
+
+// Writes an archive containing the specified amount of empty files.
+func writeLargeArchive(b *testing.B, count int) string {
+	files := make([]WriterFile, count)
+	for i := range files {
+		files[i] = WriterFile{FilePath: fmt.Sprintf("game/images/%06d.png", i)}
+	}
+	return writeTestArchive(b, files)
+}
+
+// Measures the memory used to list an archive with 500k files by reading all indices first.
+func BenchmarkListSorted(b *testing.B) {
+	path := writeLargeArchive(b, 500000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		archive, err := NewArchive(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		list, err := archive.GetFiles()
+		if err != nil || len(list) != 500000 {
+			b.Fatal(err)
+		}
+		archive.Close()
+	}
+}
+
+// Measures the memory used to list an archive with 500k files while the file tree is parsed.
+func BenchmarkListStreaming(b *testing.B) {
+	path := writeLargeArchive(b, 500000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		count := 0
+		_, err := WalkArchive(path, func(index ArchiveIndex) error {
+			count++
+			return nil
+		})
+		if err != nil || count != 500000 {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestNewArchiveLargeKey(t *testing.T) {
+	// Offsets obfuscated with a key above 0x7fffffff are stored as LONG1 in the pickle.
+	files := testFiles()
+	archive, err := NewArchive(writeTestArchive(t, files, WithWriterKey(0xdeadbeef)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	assertArchiveContents(t, archive, files)
+}
+
+func TestReadConcurrent(t *testing.T) {
+	files := testFiles()
+	archive, err := NewArchive(writeTestArchive(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	// Read all files from several goroutines at once; run with -race to detect unsynchronized access.
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func() {
+			for j, v := range archive.Indices {
+				data, err := archive.Read(&v)
+				if err == nil && !bytes.Equal(data, files[j].Data) {
+					err = fmt.Errorf("%s: contents differ", v.FilePath)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadChangedIndices(t *testing.T) {
+	files := testFiles()
+	archive, err := NewArchive(writeTestArchive(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	// Indices added after parsing are found by comparing them one by one.
+	index := archive.Indices[2]
+	index.FilePath = "copy.rpyc"
+	archive.Indices = append(archive.Indices, index)
+	data, err := archive.Read(&index)
+	if err != nil || !bytes.Equal(data, files[2].Data) {
+		t.Fatalf("failed to read added index: %v", err)
+	}
+
+	// Indices which are not part of the archive are rejected.
+	index.Length++
+	if _, err := archive.Read(&index); err == nil {
+		t.Fatal("expected error for unknown index")
+	}
+}
+
+// Reads the last file of an archive with 50k files using the lookup table built by NewArchive.
+// Without the table every read compares the indices one by one, like before the table was added.
+// Reordering the indices in place, e.g. by sorting them, must not disable the table.
+func benchmarkReadLookup(b *testing.B, lookup bool, reorder bool) {
+	files := make([]WriterFile, 50000)
+	for i := range files {
+		files[i] = WriterFile{FilePath: fmt.Sprintf("game/images/%05d.png", i), Data: []byte("data")}
+	}
+	archive, err := NewArchive(writeTestArchive(b, files))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer archive.Close()
+
+	if !lookup {
+		archive.lookup = nil
+	}
+	if reorder {
+		sort.Slice(archive.Indices, func(i, j int) bool {
+			return archive.Indices[i].FilePath > archive.Indices[j].FilePath
+		})
+	}
+	index := archive.Indices[len(archive.Indices) - 1]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := archive.Read(&index)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadLinear(b *testing.B) {
+	benchmarkReadLookup(b, false, false)
+}
+
+func BenchmarkReadLookup(b *testing.B) {
+	benchmarkReadLookup(b, true, false)
+}
+
+func BenchmarkReadLookupReordered(b *testing.B) {
+	benchmarkReadLookup(b, true, true)
+}
+
+// Writes the file bodies into a data file and the file tree into a separate zlib compressed index file.
+func writeIndexPair(t *testing.T, files []WriterFile) (string, string) {
+	t.Helper()
+	var data bytes.Buffer
+	indices := make([]ArchiveIndex, len(files))
+	for i, v := range files {
+		indices[i] = ArchiveIndex{v.FilePath, int64(data.Len()), len(v.Data), v.Data[:v.PrefixLength]}
+		data.Write(v.Data[v.PrefixLength:])
+	}
+
+	var index bytes.Buffer
+	stream := zlib.NewWriter(&index)
+	stream.Write(Pickle(indices))
+	stream.Close()
+
+	directory := t.TempDir()
+	dataPath, indexPath := filepath.Join(directory, "data.rpa"), filepath.Join(directory, "data.rpi")
+	for path, contents := range map[string][]byte{dataPath: data.Bytes(), indexPath: index.Bytes()} {
+		err := os.WriteFile(path, contents, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dataPath, indexPath
+}
+
+func TestNewArchiveWithIndex(t *testing.T) {
+	files := testFiles()
+	dataPath, indexPath := writeIndexPair(t, files)
+	archive, err := NewArchiveWithIndex(dataPath, indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	if archive.Version != 1 || !archive.IsValid() || archive.FileName != "data.rpa" {
+		t.Fatalf("unexpected archive %s (version %d)", archive.FileName, archive.Version)
+	}
+	assertArchiveContents(t, archive, files)
+
+	// The data file is not a valid index file and the index file must exist.
+	if _, err := NewArchiveWithIndex(dataPath, dataPath); err == nil {
+		t.Fatal("expected error for data file used as index")
+	}
+	if _, err := NewArchiveWithIndex(dataPath, filepath.Join(filepath.Dir(dataPath), "missing.rpi")); err == nil {
+		t.Fatal("expected error for missing index file")
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	a := ArchiveIndex{"a.bin", 0, 10, nil}
+	b := ArchiveIndex{"b.bin", 5, 10, nil}
+	c := ArchiveIndex{"c.bin", 15, 5, nil}
+	contained := ArchiveIndex{"contained.bin", 2, 2, nil}
+	empty := ArchiveIndex{"empty.bin", 7, 0, nil}
+	// The prefix is not stored in the body, so the body ends at offset 24.
+	prefixed := ArchiveIndex{"prefixed.bin", 20, 8, []byte("PREF")}
+	next := ArchiveIndex{"next.bin", 24, 4, nil}
+
+	archive := &Archive{Indices: []ArchiveIndex{next, c, b, empty, prefixed, a, contained}}
+	expected := [][2]ArchiveIndex{{a, contained}, {a, b}}
+	if overlaps := archive.Overlaps(); !reflect.DeepEqual(overlaps, expected) {
+		t.Fatalf("expected %v, got %v", expected, overlaps)
+	}
+
+	// Archives written without overlapping files have no overlaps.
+	written, err := NewArchive(writeTestArchive(t, testFiles(), WithPadding(0, 4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer written.Close()
+	if overlaps := written.Overlaps(); len(overlaps) > 0 {
+		t.Fatalf("unexpected overlaps %v", overlaps)
+	}
+}
+
+func TestLooksObfuscated(t *testing.T) {
+	valid := []ArchiveIndex{{"images/a.png", 51, 100, nil}, {"script.rpyc", 151, 20, []byte("RENPY")}, {"audio/ä.ogg", 166, 0, nil}}
+	tests := []struct {
+		name string
+		indices []ArchiveIndex
+		expected bool
+	}{
+		{"valid", valid, false},
+		{"empty", nil, false},
+		{"one unprintable path", append([]ArchiveIndex{{"\x01\x02", 51, 1, nil}}, valid...), false},
+		{"mostly unprintable paths", []ArchiveIndex{{"\x01\x02", 51, 1, nil}, {"\xff\xfe", 52, 1, nil}, {"a.png", 53, 1, nil}}, true},
+		{"empty paths", []ArchiveIndex{{"", 51, 1, nil}, {"", 52, 1, nil}}, true},
+		{"mostly negative offsets", []ArchiveIndex{{"a.png", -5, 1, nil}, {"b.png", -0x12345678, 1, nil}, {"c.png", 51, 1, nil}}, true},
+		{"mostly beyond the archive", []ArchiveIndex{{"a.png", 51, 1000, nil}, {"b.png", 1 << 40, 1, nil}, {"c.png", 51, 1, nil}}, true},
+		{"prefix longer than file", []ArchiveIndex{{"a.png", 51, 1, []byte("PREFIX")}, {"b.png", 52, 2, []byte("PREFIX")}}, true},
+	}
+	for _, v := range tests {
+		archive := &Archive{Indices: v.indices, size: 500}
+		if archive.LooksObfuscated() != v.expected {
+			t.Fatalf("%s: expected %v", v.name, v.expected)
+		}
+	}
+}
+
+// Writes an archive whose header key differs from the key used to obfuscate the indices.
+func writeWrongKeyArchive(t *testing.T, files []WriterFile) string {
+	t.Helper()
+	path := writeTestArchive(t, files, WithWriterKey(0x7654321))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data[25:33], "42424242")
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewArchiveObfuscated(t *testing.T) {
+	unprintable := []WriterFile{{FilePath: "\x01\x02\x03", Data: []byte("A")}, {FilePath: "\x7f\x10", Data: []byte("B")}, {FilePath: "a.png", Data: []byte("C")}}
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"unprintable paths", writeTestArchive(t, unprintable)},
+		{"out-of-range offsets", writeWrongKeyArchive(t, testFiles())},
+	}
+	for _, v := range tests {
+		_, err := NewArchive(v.path, WithStrict())
+		if !errors.Is(err, ErrObfuscated) {
+			t.Fatalf("%s: expected ErrObfuscated in strict mode, got %v", v.name, err)
+		}
+
+		// Without strict mode only a warning is printed.
+		archive, err := NewArchive(v.path)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		if !archive.LooksObfuscated() {
+			t.Fatalf("%s: expected indices to look obfuscated", v.name)
+		}
+		archive.Close()
+	}
+
+	// Regular archives are accepted in strict mode.
+	archive, err := NewArchive(writeTestArchive(t, testFiles()), WithStrict())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	if archive.LooksObfuscated() {
+		t.Fatal("regular archive looks obfuscated")
+	}
+}
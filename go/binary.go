@@ -1,28 +1,51 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 )
 
-// Reads the next integer from the byte reader and returns its value.
+// Reads exactly enough bytes from the reader to fill the buffer.
+// Partial reads are repeated until the buffer is full; if the stream ends before that, a truncated pickle error is returned.
+func readFull(reader io.Reader, buffer []byte) error {
+	_, err := io.ReadFull(reader, buffer)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("binary: truncated pickle")
+	}
+	return err
+}
+
+// Reads the next integer from the reader and returns its value.
 // The byte order of the integer is little endian.
-func readInteger(reader *bytes.Reader) (int32, error) {
+func readInteger(reader io.Reader) (int32, error) {
 	// Create buffer large enough to fit in a whole 32-bit integer.
 	buffer := make([]byte, 4)
-	bytesRead, err := reader.Read(buffer)
+	err := readFull(reader, buffer)
 	if err != nil {
 		return 0, err
-	} else if bytesRead != 4 {
-		return 0, errors.New("binary: insufficient bytes left in stream")
 	}
 
 	// Convert byte array to integer.
 	return int32(binary.LittleEndian.Uint32(buffer)), nil
 }
 
+// Decodes the specified little endian two's complement integer of up to 8 bytes.
+// An empty buffer represents zero.
+func decodeLong(buffer []byte) int64 {
+	var number uint64
+	for i := len(buffer) - 1; i >= 0; i-- {
+		number = number << 8 | uint64(buffer[i])
+	}
+
+	// Extend the sign of negative integers shorter than 8 bytes.
+	if length := len(buffer); length > 0 && length < 8 && buffer[length - 1] >= 0x80 {
+		number |= ^uint64(0) << (8 * uint(length))
+	}
+	return int64(number)
+}
+
 // Selects the best matching integer type for the specified object and returns its value.
 func castInteger(object interface{}) (int, error) {
 	switch t := object.(type) {
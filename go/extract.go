@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Contains the errors of all files which could not be extracted.
+type ExtractError struct {
+	Errors []error
+}
+
+func (err *ExtractError) Error() string {
+	return fmt.Sprintf("failed to extract %d files", len(err.Errors))
+}
+
+// Extracts all files from the archive into the specified directory.
+// Only the indices for which keep returns true are extracted; if keep is nil, all files are extracted.
+// Files which could not be extracted are skipped; their errors are returned as *ExtractError.
+func (archive *Archive) ExtractAllFunc(directory string, keep func(ArchiveIndex) bool) error {
+	return archive.extractAll(directory, archive.Indices, keep, false)
+}
+
+// Extracts the specified indices in the order of the slice into the directory.
+// If convertImages is set, images are re-encoded as PNG instead of being extracted unchanged.
+func (archive *Archive) extractAll(directory string, indices []ArchiveIndex, keep func(ArchiveIndex) bool, convertImages bool) error {
+	var errs []error
+	for _, v := range indices {
+		if keep != nil && !keep(v) {
+			continue
+		}
+
+		extract := archive.extractIndex
+		if convertImages && isConvertibleImage(v.FilePath) {
+			extract = archive.extractImage
+		}
+		err := extract(directory, &v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", v.FilePath, err))
+		}
+	}
+	if len(errs) > 0 {
+		return &ExtractError{errs}
+	}
+	return nil
+}
+
+// Returns a predicate which keeps the indices for which all of the specified predicates return true.
+// Nil predicates are ignored.
+func keepAll(predicates ...func(ArchiveIndex) bool) func(ArchiveIndex) bool {
+	return func(index ArchiveIndex) bool {
+		for _, keep := range predicates {
+			if keep != nil && !keep(index) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Returns the path of the relative file path within the directory.
+// Fails if the file path is absolute or leaves the directory (e.g. ../../.bashrc).
+func outputPath(directory, filePath string) (string, error) {
+	if filepath.IsAbs(filePath) || strings.HasPrefix(filePath, "/") || strings.HasPrefix(filePath, "\\") {
+		return "", errors.New("absolute file path")
+	}
+
+	f := filepath.Join(directory, filepath.FromSlash(filePath))
+	relative, err := filepath.Rel(directory, f)
+	if err != nil || relative == "." || relative == ".." || strings.HasPrefix(relative, ".." + string(filepath.Separator)) {
+		return "", errors.New("file path leaves the output directory")
+	}
+	return f, nil
+}
+
+// Extracts the specified file from the archive into the directory.
+// Sub-directories of the relative file path are created if necessary.
+func (archive *Archive) extractIndex(directory string, index *ArchiveIndex) error {
+	f, err := outputPath(directory, index.FilePath)
+	if err != nil {
+		return err
+	}
+
+	data, err := archive.Read(index)
+	if err != nil {
+		return fmt.Errorf("failed to read file data: %v", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(f), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create sub-directory: %v", err)
+	}
+
+	file, err := os.Create(f)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to write contents: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Returns the slash-separated relative paths of all files within the directory.
+func listDirectory(t *testing.T, directory string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(directory, func(f string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relative, err := filepath.Rel(directory, f)
+		files = append(files, filepath.ToSlash(relative))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestExtractAllFunc(t *testing.T) {
+	files := append(testFiles(), WriterFile{FilePath: "images/sub/c.PNG", Data: []byte("UPPER"), PrefixLength: 2})
+	archive, err := NewArchive(writeTestArchive(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	directory := t.TempDir()
+	err = archive.ExtractAllFunc(directory, func(index ArchiveIndex) bool {
+		return path.Ext(index.FilePath) == ".png"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extracted := listDirectory(t, directory)
+	if !reflect.DeepEqual(extracted, []string{"images/a.png"}) {
+		t.Fatalf("unexpected files %v", extracted)
+	}
+	data, err := os.ReadFile(filepath.Join(directory, "images", "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, files[0].Data) {
+		t.Fatal("contents differ")
+	}
+
+	// A nil predicate extracts every file.
+	directory = t.TempDir()
+	err = archive.ExtractAllFunc(directory, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extracted := listDirectory(t, directory); len(extracted) != len(files) {
+		t.Fatalf("unexpected files %v", extracted)
+	}
+}
+
+func TestExtractAllFuncTraversal(t *testing.T) {
+	files := []WriterFile{
+		{FilePath: "../outside.txt", Data: []byte("A")},
+		{FilePath: "images/../../outside.txt", Data: []byte("B")},
+		{FilePath: "/absolute.txt", Data: []byte("C")},
+		{FilePath: "images/../inside.txt", Data: []byte("D")},
+	}
+	archive, err := NewArchive(writeTestArchive(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	// Extract into a sub-directory so escaped files would be visible in the parent.
+	parent := t.TempDir()
+	directory := filepath.Join(parent, "out")
+	err = archive.ExtractAllFunc(directory, nil)
+	extractErr, ok := err.(*ExtractError)
+	if !ok || len(extractErr.Errors) != 3 {
+		t.Fatalf("expected three extraction errors, got %v", err)
+	}
+
+	if extracted := listDirectory(t, parent); !reflect.DeepEqual(extracted, []string{"out/inside.txt"}) {
+		t.Fatalf("unexpected files %v", extracted)
+	}
+
+	// Converted images are written to the same paths and must be rejected as well.
+	for _, v := range archive.Indices[:3] {
+		if err := archive.extractImageAsPNG(directory, &v); err == nil {
+			t.Fatalf("%s: expected error", v.FilePath)
+		}
+	}
+}
+
+func TestKeepAll(t *testing.T) {
+	large := func(index ArchiveIndex) bool { return index.Length > 10 }
+	image := func(index ArchiveIndex) bool { return path.Ext(index.FilePath) == ".png" }
+	keep := keepAll(large, nil, image)
+
+	tests := []struct {
+		index ArchiveIndex
+		expected bool
+	}{
+		{ArchiveIndex{"a.png", 0, 20, nil}, true},
+		{ArchiveIndex{"a.png", 0, 5, nil}, false},
+		{ArchiveIndex{"a.jpg", 0, 20, nil}, false},
+	}
+	for _, v := range tests {
+		if keep(v.index) != v.expected {
+			t.Fatalf("%v: expected %v", v.index, v.expected)
+		}
+	}
+	if !keepAll()(ArchiveIndex{}) {
+		t.Fatal("expected empty predicate list to keep all indices")
+	}
+}
+
+// Streams every file of a large archive in a random index order or in the physical order of the bodies.
+// The physical order reads the archive sequentially, the index order jumps between the file bodies.
+// The bodies are discarded so the read pattern is measured and not the file system writes of the extraction.
+func benchmarkExtractOrder(b *testing.B, physicalOrder bool) {
+	files := make([]WriterFile, 2000)
+	for i := range files {
+		files[i] = WriterFile{FilePath: fmt.Sprintf("files/%04d.bin", i), Data: bytes.Repeat([]byte{byte(i)}, 32 * 1024)}
+	}
+	archive, err := NewArchive(writeTestArchive(b, files))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer archive.Close()
+
+	// Shuffle a copy so the indices of the archive keep their order.
+	shuffled := make([]ArchiveIndex, len(archive.Indices))
+	copy(shuffled, archive.Indices)
+	rand.New(rand.NewSource(1)).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	b.SetBytes(int64(len(files) * 32 * 1024))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// The sorting is part of the physical order extraction.
+		indices := shuffled
+		if physicalOrder {
+			indices = archive.indicesByOffset()
+		}
+		for j := range indices {
+			_, err := archive.WriteIndexTo(io.Discard, &indices[j])
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkExtractIndexOrder(b *testing.B) {
+	benchmarkExtractOrder(b, false)
+}
+
+func BenchmarkExtractPhysicalOrder(b *testing.B) {
+	benchmarkExtractOrder(b, true)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Contains the information parsed from the header of an RPA archive.
+type archiveHeader struct {
+	version int
+	offset int64
+	key int
+	comment string
+}
+
+// Removes the obfuscation of the offset and the length of the specified index using the key of the header.
+// Only RPA-3.0 archives obfuscate their indices; the key salt of the settings is XORed into the key.
+func (header archiveHeader) deobfuscate(index *ArchiveIndex, settings archiveOptions) {
+	if header.version != 3 {
+		return
+	}
+	key := header.key ^ settings.keySalt
+	index.Offset = index.Offset ^ int64(key)
+	index.Length = index.Length ^ key
+}
+
+// Represents a recognized header variant and the function used to parse headers of this variant.
+type headerVariant struct {
+	prefix string
+	parse func(fields []string) (archiveHeader, error)
+}
+
+// Contains all recognized header variants.
+// The header of an archive is matched against the prefixes in the order of the table.
+var headerVariants = []headerVariant{
+	{"RPA-2.0", parseHeaderV2},
+	{"RPA-3.0", parseHeaderV3},
+	{"RPA-3.2", parseHeaderV32},
+	{"ZiX-12A", parseHeaderZiX},
+	{"ZiX-12B", parseHeaderZiX},
+}
+
+// Parses the specified header line of an RPA archive using the matching header variant.
+// If the header is not recognized, an error is returned in strict mode;
+// otherwise a warning is printed and the header is parsed like an RPA-3.0 header.
+func parseHeader(header string, strict bool) (archiveHeader, error) {
+	fields := strings.Fields(header)
+	for _, v := range headerVariants {
+		if strings.HasPrefix(header, v.prefix) {
+			return v.parse(fields)
+		}
+	}
+
+	if strict {
+		return archiveHeader{}, errors.New("invalid archive version")
+	}
+	fmt.Fprintf(os.Stdout, "(Warning) Unrecognized archive header %q, assuming RPA-3.0 layout.\n", strings.TrimSpace(header))
+	return parseHeaderV3(fields)
+}
+
+// Parses the offset of the file tree which follows the version in every header.
+func parseHeaderOffset(fields []string) (int64, error) {
+	if len(fields) < 2 {
+		return 0, errors.New("invalid header")
+	}
+	return strconv.ParseInt(fields[1], 16, 64)
+}
+
+// Parses an RPA-2.0 header which consists of the offset of the file tree.
+// Any tokens following the offset are stored as comment.
+func parseHeaderV2(fields []string) (archiveHeader, error) {
+	offset, err := parseHeaderOffset(fields)
+	if err != nil {
+		return archiveHeader{}, err
+	}
+	return archiveHeader{version: 2, offset: offset, comment: strings.Join(fields[2:], " ")}, nil
+}
+
+// Parses an RPA-3.0 header which consists of the offset of the file tree followed by the key tokens.
+// The deobfuscation key is calculated by combining all hexadecimal key tokens using XOR.
+// The first token which is not hexadecimal starts a comment (e.g. a build comment or engine version),
+// which is excluded from the key derivation together with all following tokens.
+// Comment words which happen to be hexadecimal (e.g. "cafe" or "add") cannot be told apart from key tokens
+// and are still XORed into the key; only the tokens from the first non-hexadecimal word on are the comment.
+func parseHeaderV3(fields []string) (archiveHeader, error) {
+	offset, err := parseHeaderOffset(fields)
+	if err != nil {
+		return archiveHeader{}, err
+	}
+
+	header := archiveHeader{version: 3, offset: offset}
+	for i, v := range fields[2:] {
+		parsed, err := strconv.ParseUint(v, 16, 64)
+		if err != nil {
+			header.comment = strings.Join(fields[2 + i:], " ")
+			break
+		}
+		header.key ^= int(parsed)
+	}
+	return header, nil
+}
+
+// Parses an RPA-3.2 header which contains an additional token between the offset and the key.
+func parseHeaderV32(fields []string) (archiveHeader, error) {
+	if len(fields) < 4 {
+		return archiveHeader{}, errors.New("invalid header")
+	}
+	return parseHeaderV3(append([]string{fields[0], fields[1]}, fields[3:]...))
+}
+
+// Parses a ZiX header which uses the RPA-3.0 layout.
+// ZiX archives additionally obfuscate the file tree with a scheme defined by the game loader, which is not supported;
+// the parsed indices are usually rejected as obfuscated.
+func parseHeaderZiX(fields []string) (archiveHeader, error) {
+	fmt.Fprintf(os.Stdout, "(Warning) %s archives use a custom obfuscation scheme which is not supported.\n", fields[0])
+	return parseHeaderV3(fields)
+}
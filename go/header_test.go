@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		expected archiveHeader
+	}{
+		{"RPA-2.0 000000000012ab3c\n", archiveHeader{version: 2, offset: 0x12ab3c}},
+		{"RPA-3.0 0000000000000100 42424242\n", archiveHeader{version: 3, offset: 0x100, key: 0x42424242}},
+		{"RPA-3.0 0000000000000100 deadbeef\n", archiveHeader{version: 3, offset: 0x100, key: 0xdeadbeef}},
+		{"RPA-3.0 0000000000000100 0000ff00 000000ff\n", archiveHeader{version: 3, offset: 0x100, key: 0xffff}},
+		{"RPA-3.2 0000000000000100 00000001 42424242\n", archiveHeader{version: 3, offset: 0x100, key: 0x42424242}},
+		{"ZiX-12A 0000000000000100 42424242\n", archiveHeader{version: 3, offset: 0x100, key: 0x42424242}},
+		{"ZiX-12B 0000000000000100 42424242\n", archiveHeader{version: 3, offset: 0x100, key: 0x42424242}},
+	}
+	for _, v := range tests {
+		for _, strict := range []bool{false, true} {
+			parsed, err := parseHeader(v.header, strict)
+			if err != nil {
+				t.Fatalf("%q: %v", v.header, err)
+			}
+			if parsed != v.expected {
+				t.Fatalf("%q: expected %+v, got %+v", v.header, v.expected, parsed)
+			}
+		}
+	}
+}
+
+func TestParseHeaderUnrecognized(t *testing.T) {
+	header := "RPA-4.0 0000000000000100 42424242\n"
+	_, err := parseHeader(header, true)
+	if err == nil {
+		t.Fatal("expected error in strict mode")
+	}
+
+	// The header is parsed like an RPA-3.0 header in lenient mode.
+	parsed, err := parseHeader(header, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := archiveHeader{version: 3, offset: 0x100, key: 0x42424242}
+	if parsed != expected {
+		t.Fatalf("expected %+v, got %+v", expected, parsed)
+	}
+}
+
+func TestParseHeaderInvalid(t *testing.T) {
+	for _, v := range []string{"RPA-3.0\n", "RPA-3.0 xyz 42424242\n", "RPA-3.2 0000000000000100 42424242\n"} {
+		if _, err := parseHeader(v, false); err == nil {
+			t.Fatalf("%q: expected error", v)
+		}
+	}
+}
+
+func TestParseHeaderComment(t *testing.T) {
+	tests := []struct {
+		header string
+		key int
+		comment string
+	}{
+		{"RPA-3.0 0000000000000100 42424242 built with Ren'Py 7.4.11\n", 0x42424242, "built with Ren'Py 7.4.11"},
+		{"RPA-3.0 0000000000000100 42424242 0000ff00 v2\n", 0x4242bd42, "v2"},
+		// Hexadecimal comment words are indistinguishable from key tokens and are XORed into the key.
+		{"RPA-3.0 0000000000000100 42424242 cafe build\n", 0x424288bc, "build"},
+		{"RPA-3.2 0000000000000100 00000001 42424242 nightly\n", 0x42424242, "nightly"},
+		{"RPA-2.0 0000000000000100 engine 6.99\n", 0, "engine 6.99"},
+	}
+	for _, v := range tests {
+		parsed, err := parseHeader(v.header, true)
+		if err != nil {
+			t.Fatalf("%q: %v", v.header, err)
+		}
+		if parsed.offset != 0x100 || parsed.key != v.key || parsed.comment != v.comment {
+			t.Fatalf("%q: expected key 0x%x and comment %q, got %+v", v.header, v.key, v.comment, parsed)
+		}
+	}
+}
+
+func TestNewArchiveComment(t *testing.T) {
+	// Rewrite the header of an archive with a trailing comment and move the contents behind it.
+	// Only the file tree is moved correctly, the file offsets are not adjusted.
+	var buffer bytes.Buffer
+	files := testFiles()
+	err := WriteArchive(&buffer, files, WithWriterKey(0x42424242))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	offset, err := strconv.ParseInt(string(data[8:24]), 16, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	comment := " built with Ren'Py"
+	header := fmt.Sprintf("RPA-3.0 %016x 42424242%s\n", offset + int64(len(comment)), comment)
+	shifted := append([]byte(header), data[len(formatHeader(0, 0)):]...)
+	archive, err := NewArchiveFromReaderAt(bytes.NewReader(shifted), int64(len(shifted)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.Comment != "built with Ren'Py" || len(archive.Indices) != len(files) {
+		t.Fatalf("unexpected comment %q or %d indices", archive.Comment, len(archive.Indices))
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Contains the file extensions of the image formats which can be converted to PNG.
+// WEBP images are only converted if the decoder is compiled in (see images_webp.go).
+var convertibleImageExtensions = map[string]bool{
+	".jpg": true,
+	".jpeg": true,
+	".gif": true,
+}
+
+// Returns a value indicating whether the file at the specified path is an image which can be converted to PNG.
+func isConvertibleImage(filePath string) bool {
+	return convertibleImageExtensions[strings.ToLower(path.Ext(filePath))]
+}
+
+// Returned if an image could not be converted to PNG and was extracted unchanged instead.
+type ConvertError struct {
+	Err error
+}
+
+func (err *ConvertError) Error() string {
+	return fmt.Sprintf("failed to convert image (%v), extracted it unchanged", err.Err)
+}
+
+func (err *ConvertError) Unwrap() error {
+	return err.Err
+}
+
+// Extracts the specified image from the archive into the directory as PNG.
+// If the image cannot be converted, it is extracted unchanged and a *ConvertError is returned.
+func (archive *Archive) extractImage(directory string, index *ArchiveIndex) error {
+	err := archive.extractImageAsPNG(directory, index)
+	if err == nil {
+		return nil
+	}
+
+	extractErr := archive.extractIndex(directory, index)
+	if extractErr != nil {
+		return extractErr
+	}
+	return &ConvertError{err}
+}
+
+// Returns a value indicating whether the archive contains a file with the specified relative path.
+// The path is looked up in the table of the parsed indices; only archives without a table are searched one by one.
+func (archive *Archive) containsPath(filePath string) bool {
+	if archive.lookup != nil {
+		_, ok := archive.lookup[filePath]
+		return ok
+	}
+	for _, v := range archive.Indices {
+		if v.FilePath == filePath {
+			return true
+		}
+	}
+	return false
+}
+
+// Extracts the specified image from the archive into the directory and re-encodes it as PNG.
+// The extension of the extracted file is replaced by .png; sub-directories are created if necessary.
+// Fails if the archive contains a file with the path of the converted image or if the converted file already exists,
+// so an archived PNG or another converted image is never overwritten.
+func (archive *Archive) extractImageAsPNG(directory string, index *ArchiveIndex) error {
+	filePath := strings.TrimSuffix(index.FilePath, path.Ext(index.FilePath)) + ".png"
+	if archive.containsPath(filePath) {
+		return fmt.Errorf("archive already contains %s", filePath)
+	}
+	f, err := outputPath(directory, filePath)
+	if err != nil {
+		return err
+	}
+
+	reader, err := archive.OpenIndex(index)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer reader.Close()
+
+	// Decode the image directly from the archive.
+	img, _, err := image.Decode(reader)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(f), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create sub-directory: %v", err)
+	}
+
+	file, err := os.OpenFile(f, os.O_WRONLY | os.O_CREATE | os.O_EXCL, 0666)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists", filePath)
+	} else if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	err = png.Encode(file, img)
+	if err != nil {
+		file.Close()
+		os.Remove(f)
+		return fmt.Errorf("failed to encode image: %v", err)
+	}
+	return nil
+}
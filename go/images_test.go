@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Returns a JPEG encoded image consisting of a red and a blue half.
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for x := 0; x < 16; x++ {
+		for y := 0; y < 8; y++ {
+			if x < 8 {
+				img.Set(x, y, color.RGBA{0xFF, 0x00, 0x00, 0xFF})
+			} else {
+				img.Set(x, y, color.RGBA{0x00, 0x00, 0xFF, 0xFF})
+			}
+		}
+	}
+	var buffer bytes.Buffer
+	err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestExtractImageAsPNG(t *testing.T) {
+	files := []WriterFile{{FilePath: "images/photo.JPG", Data: testJPEG(t), PrefixLength: 16}, {FilePath: "script.rpyc", Data: []byte("SCRIPT")}}
+	archive, err := NewArchive(writeTestArchive(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	if !isConvertibleImage(files[0].FilePath) || isConvertibleImage("images/icon.png") || isConvertibleImage(files[1].FilePath) {
+		t.Fatal("unexpected convertible images")
+	}
+
+	directory := t.TempDir()
+	err = archive.extractImageAsPNG(directory, &archive.Indices[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The converted file is a PNG with the colors of the original image.
+	file, err := os.Open(filepath.Join(directory, "images", "photo.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, format, err := image.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || img.Bounds() != image.Rect(0, 0, 16, 8) {
+		t.Fatalf("unexpected %s image with bounds %v", format, img.Bounds())
+	}
+	for _, v := range []struct {
+		x int
+		red bool
+	}{{2, true}, {13, false}} {
+		r, _, b, _ := img.At(v.x, 4).RGBA()
+		if (r > b) != v.red {
+			t.Fatalf("unexpected color at %d: %d %d", v.x, r >> 8, b >> 8)
+		}
+	}
+
+	// Files which are no images cannot be converted and no file is written.
+	err = archive.extractImageAsPNG(directory, &archive.Indices[1])
+	if err == nil {
+		t.Fatal("expected error for file which is no image")
+	}
+	if _, err := os.Stat(filepath.Join(directory, "script.png")); !os.IsNotExist(err) {
+		t.Fatal("unexpected converted file")
+	}
+}
+
+func TestExtractImageAsPNGConflict(t *testing.T) {
+	jpg := testJPEG(t)
+	files := []WriterFile{
+		{FilePath: "images/a.jpg", Data: jpg},
+		{FilePath: "images/a.png", Data: []byte("ARCHIVED PNG")},
+		{FilePath: "images/b.jpg", Data: jpg},
+		{FilePath: "images/b.gif", Data: []byte("GIF")},
+	}
+	archive, err := NewArchive(writeTestArchive(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	// Converting a.jpg would replace the archived a.png.
+	directory := t.TempDir()
+	err = archive.extractImageAsPNG(directory, &archive.Indices[0])
+	if err == nil || !strings.Contains(err.Error(), "archive already contains images/a.png") {
+		t.Fatalf("expected conflict with archived file, got %v", err)
+	}
+
+	// A converted file is not overwritten by the conversion of another image with the same name.
+	err = os.MkdirAll(filepath.Join(directory, "images"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(directory, "images", "b.png"), []byte("CONVERTED"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = archive.extractImageAsPNG(directory, &archive.Indices[2])
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected conflict with existing file, got %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(directory, "images", "b.png"))
+	if err != nil || string(data) != "CONVERTED" {
+		t.Fatalf("existing file was modified: %q (%v)", data, err)
+	}
+}
+
+func TestExtractAllConvertImages(t *testing.T) {
+	jpg := testJPEG(t)
+	files := []WriterFile{
+		{FilePath: "images/photo.jpg", Data: jpg, PrefixLength: 8},
+		{FilePath: "images/a.jpg", Data: jpg},
+		{FilePath: "images/a.png", Data: []byte("ARCHIVED PNG")},
+		{FilePath: "images/broken.gif", Data: []byte("GIF89a broken")},
+		{FilePath: "script.rpyc", Data: []byte("SCRIPT")},
+	}
+	archive, err := NewArchive(writeTestArchive(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	// Images which cannot be converted are extracted unchanged and reported.
+	directory := t.TempDir()
+	err = archive.extractAll(directory, archive.Indices, nil, true)
+	extractErr, ok := err.(*ExtractError)
+	if !ok || len(extractErr.Errors) != 2 {
+		t.Fatalf("expected two conversion errors, got %v", err)
+	}
+	for _, v := range extractErr.Errors {
+		var convertErr *ConvertError
+		if !errors.As(v, &convertErr) {
+			t.Fatalf("expected conversion error, got %v", v)
+		}
+	}
+
+	expected := []string{"images/a.jpg", "images/a.png", "images/broken.gif", "images/photo.png", "script.rpyc"}
+	if extracted := listDirectory(t, directory); !reflect.DeepEqual(extracted, expected) {
+		t.Fatalf("expected %v, got %v", expected, extracted)
+	}
+	data, err := os.ReadFile(filepath.Join(directory, "images", "a.jpg"))
+	if err != nil || !bytes.Equal(data, jpg) {
+		t.Fatalf("unexpected contents of unconverted image (%v)", err)
+	}
+}
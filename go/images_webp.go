@@ -0,0 +1,13 @@
+//go:build webp
+// +build webp
+
+package main
+
+import (
+	_ "golang.org/x/image/webp"
+)
+
+// Registers the WEBP decoder, which requires golang.org/x/image; build with -tags webp to enable it.
+func init() {
+	convertibleImageExtensions[".webp"] = true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Formats the specified amount of bytes as a human-readable string (e.g. 1.5 KiB).
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	// Determine the largest fitting binary unit.
+	divisor, exponent := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		divisor *= unit
+		exponent++
+	}
+	// Use the next unit if the value would be rounded up to 1024.0 (e.g. 1024.0 KiB instead of 1.0 MiB).
+	value := float64(size)/float64(divisor)
+	if value >= unit - 0.05 && exponent < 5 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTPE"[exponent])
+}
+
+// Formats the specified offset as hexadecimal number with at least eight digits.
+// Negative offsets of malformed indices are preceded by a minus sign.
+func formatOffset(offset int64) string {
+	if offset < 0 {
+		return fmt.Sprintf("-0x%08x", uint64(-offset))
+	}
+	return fmt.Sprintf("0x%08x", offset)
+}
+
+// Writes a columnar listing of the specified archive indices to the writer.
+// Every line contains the size, the hexadecimal offset, whether the index has a prefix and the relative file path.
+// The listing is sorted alphabetically by the file path, the passed slice is not modified.
+func WriteLongList(writer io.Writer, indices []ArchiveIndex) error {
+	// Sort a copy of the indices alphabetically.
+	sorted := make([]ArchiveIndex, len(indices))
+	copy(sorted, indices)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].FilePath < sorted[j].FilePath
+	})
+
+	// Align the columns using a tab writer.
+	table := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Size\tOffset\tPrefix\tPath\n")
+	for _, v := range sorted {
+		prefix := "no"
+		if len(v.Prefix) > 0 {
+			prefix = "yes"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", formatSize(int64(v.Length)), formatOffset(v.Offset), prefix, v.FilePath)
+	}
+	return table.Flush()
+}
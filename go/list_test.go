@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{1024 * 1024 - 1, "1.0 MiB"},
+		{1024 * 1024, "1.0 MiB"},
+		{5 * 1024 * 1024 * 1024, "5.0 GiB"},
+		{1 << 62, "4.0 EiB"},
+	}
+	for _, v := range tests {
+		if formatted := formatSize(v.size); formatted != v.expected {
+			t.Fatalf("%d: expected %q, got %q", v.size, v.expected, formatted)
+		}
+	}
+}
+
+func TestWriteLongList(t *testing.T) {
+	indices := []ArchiveIndex{
+		{"script.rpyc", 0x12345678, 1024 * 1024, []byte("RENPY")},
+		{"images/b.png", 0x1234, 1024, nil},
+		{"audio/empty.ogg", 0x40, 0, []byte{}},
+		{"broken.bin", -1, 1023, nil},
+		{"huge.bin", 0x123456789, 1024 * 1024 - 1, []byte("X")},
+	}
+	var buffer bytes.Buffer
+	err := WriteLongList(&buffer, indices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `Size     Offset       Prefix  Path
+0 B      0x00000040   no      audio/empty.ogg
+1023 B   -0x00000001  no      broken.bin
+1.0 MiB  0x123456789  yes     huge.bin
+1.0 KiB  0x00001234   no      images/b.png
+1.0 MiB  0x12345678   yes     script.rpyc
+`
+	if buffer.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, buffer.String())
+	}
+
+	// The passed indices are not sorted.
+	if indices[0].FilePath != "script.rpyc" {
+		t.Fatal("indices were modified")
+	}
+}
+
+func TestWriteLongListEmpty(t *testing.T) {
+	var buffer bytes.Buffer
+	err := WriteLongList(&buffer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buffer.String() != "Size  Offset  Prefix  Path\n" {
+		t.Fatalf("unexpected listing %q", buffer.String())
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"os"
-	path2 "path"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -13,7 +16,10 @@ func main() {
 	path := filepath.Base(os.Args[0])
 	arguments := os.Args[1:]
 	if len(arguments) == 0 {
-		fmt.Println("(Info) Syntax:", path, "<archive>")
+		fmt.Println("(Info) Syntax:", path, "[options] <archive>")
+		fmt.Println("(Info) Reading:    --best-effort, --strict, --key-salt <hex>, --index <file>")
+		fmt.Println("(Info) Listing:    --list/-l [--no-sort], --list-long/-ll, --tree [--collapse], --check-overlaps")
+		fmt.Println("(Info) Extracting: --physical-order, --convert-images, --filter <glob>, --exclude <glob>, --min-size <bytes>, --pack-out <dir>")
 		os.Exit(1)
 	}
 
@@ -26,15 +32,124 @@ func main() {
 	}
 
 	// Wrap file information in archive structure.
-	archive, err := NewArchive(archivePath)
+	var options []ArchiveOption
+	if containsArgument(arguments, "--best-effort") {
+		options = append(options, WithBestEffort())
+	}
+	if containsArgument(arguments, "--strict") {
+		options = append(options, WithStrict())
+	}
+	if salt, ok := argumentValue(arguments, "--key-salt"); ok {
+		parsed, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(salt), "0x"), 16, 32)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "(Error) Invalid key salt: %v\n", err)
+			os.Exit(1)
+		}
+		options = append(options, WithKeySalt(int(parsed)))
+	}
+
+	// Print the files while the file tree is parsed without keeping the indices in memory.
+	// Archives with a separate index file are listed after they were read completely.
+	_, hasIndex := argumentValue(arguments, "--index")
+	if (containsArgument(arguments, "--list") || containsArgument(arguments, "-l")) && containsArgument(arguments, "--no-sort") && !hasIndex {
+		writer := bufio.NewWriter(os.Stdout)
+		i := 0
+		errs, err := WalkArchive(archivePath, func(index ArchiveIndex) error {
+			i++
+			_, err := fmt.Fprintf(writer, "%v. %v\n", i, index.FilePath)
+			return err
+		}, options...)
+		writer.Flush()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "(Fatal) Failed to read file list from RPA archive: %v\n", err)
+			os.Exit(4)
+		}
+		for _, v := range errs {
+			fmt.Fprintf(os.Stderr, "(Error) Skipped malformed file index: %v\n", v)
+		}
+		os.Exit(0)
+		return
+	}
+
+	// Read the file tree from a separate index file if specified.
+	var archive *Archive
+	if indexPath, ok := argumentValue(arguments, "--index"); ok {
+		archive, err = NewArchiveWithIndex(archivePath, indexPath, options...)
+	} else {
+		archive, err = NewArchive(archivePath, options...)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "(Fatal) Failed to parse the specified RPA archive: %v\n", err)
 		os.Exit(3)
 	}
 	defer archive.Close()
 
+	// Report malformed indices which were skipped.
+	for _, v := range archive.Errors {
+		fmt.Fprintf(os.Stderr, "(Error) Skipped malformed file index: %v\n", v)
+	}
+
+	// Report files whose contents overlap.
+	if containsArgument(arguments, "--check-overlaps") {
+		for _, v := range archive.Overlaps() {
+			fmt.Fprintf(os.Stdout, "(Warning) %s (at 0x%x) overlaps %s (at 0x%x).\n", v[0].FilePath, v[0].Offset, v[1].FilePath, v[1].Offset)
+		}
+	}
+
+	// List files in archive including their size, offset and prefix.
+	if containsArgument(arguments, "--list-long") || containsArgument(arguments, "-ll") {
+		if !archive.IsValid() {
+			fmt.Fprintf(os.Stderr, "(Fatal) Failed to read file list from RPA archive: invalid archive version\n")
+			os.Exit(4)
+		}
+
+		err := WriteLongList(os.Stdout, archive.Indices)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "(Fatal) Failed to write file list: %v\n", err)
+			os.Exit(4)
+		}
+		os.Exit(0)
+		return
+	}
+
+	// List files in archive as directory tree.
+	if containsArgument(arguments, "--tree") {
+		list, err := archive.GetFiles()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "(Fatal) Failed to read file list from RPA archive: %v\n", err)
+			os.Exit(4)
+		}
+
+		tree := BuildTree(list)
+		if containsArgument(arguments, "--collapse") {
+			tree.Collapse()
+		}
+		writer := bufio.NewWriter(os.Stdout)
+		WriteTree(writer, tree)
+		writer.Flush()
+		os.Exit(0)
+		return
+	}
+
 	// List file in archive.
 	if containsArgument(arguments, "--list") || containsArgument(arguments, "-l") {
+		// Print the files as they are stored in the archive without sorting them first.
+		if containsArgument(arguments, "--no-sort") {
+			writer := bufio.NewWriter(os.Stdout)
+			i := 0
+			err := archive.WalkFiles(func(path string) {
+				i++
+				fmt.Fprintf(writer, "%v. %v\n", i, path)
+			})
+			writer.Flush()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "(Fatal) Failed to read file list from RPA archive: %v\n", err)
+				os.Exit(4)
+			}
+			os.Exit(0)
+			return
+		}
+
 		list, err := archive.GetFiles()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "(Fatal) Failed to read file list from RPA archive: %v\n", err)
@@ -48,6 +163,23 @@ func main() {
 		return
 	}
 
+	// Pack file contents into a single data file with a manifest.
+	if packDirectory, ok := argumentValue(arguments, "--pack-out"); ok {
+		err := os.MkdirAll(packDirectory, os.ModePerm)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "(Error) Failed to create output directory: %v\n", err)
+			os.Exit(6)
+		}
+
+		err = archive.Pack(packDirectory)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "(Error) Failed to pack archive contents: %v\n", err)
+			os.Exit(7)
+		}
+		fmt.Println("Done.")
+		return
+	}
+
 	outputDirectory := fmt.Sprintf("rpaextract_%s", strings.TrimSuffix(archive.FileName, filepath.Ext(archive.FileName)))
 	outputStat, err := os.Stat(outputDirectory)
 	if err == nil && os.IsExist(err) && outputStat.IsDir() {
@@ -62,37 +194,60 @@ func main() {
 		os.Exit(6)
 	}
 
-	for _, v := range archive.Indices {
-		data, err := archive.Read(&v)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "(Error) Failed to read file data for %s (%v)\n", v.FilePath, err)
-			continue
-		}
-
-		f := path2.Join(outputDirectory, v.FilePath)
-		err = os.MkdirAll(filepath.Dir(f), os.ModePerm)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "(Error) Failed to create sub-directory for %s\n", v.FilePath)
-			break
-		}
+	// Optionally extract files in the order of their offset so the archive is read sequentially.
+	indices := archive.Indices
+	if containsArgument(arguments, "--physical-order") {
+		indices = archive.indicesByOffset()
+	}
 
-		file, err := os.Create(f)
+	// Compose the predicates deciding which files are extracted.
+	var predicates []func(ArchiveIndex) bool
+	if pattern, ok := argumentValue(arguments, "--filter"); ok {
+		predicates = append(predicates, matchPattern(pattern, true))
+	}
+	if pattern, ok := argumentValue(arguments, "--exclude"); ok {
+		predicates = append(predicates, matchPattern(pattern, false))
+	}
+	if value, ok := argumentValue(arguments, "--min-size"); ok {
+		minSize, err := strconv.Atoi(value)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "(Error) Failed to create file for %s\n", v.FilePath)
-			break
+			fmt.Fprintf(os.Stderr, "(Error) Invalid minimum size: %v\n", err)
+			os.Exit(1)
 		}
+		predicates = append(predicates, func(index ArchiveIndex) bool {
+			return index.Length >= minSize
+		})
+	}
 
-		_, err = file.Write(data)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "(Error) Failed to write contents for %s\n", v.FilePath)
-			break
+	// Optionally re-encode images as PNG and fall back to extracting the original file.
+	convertImages := containsArgument(arguments, "--convert-images")
+	err = archive.extractAll(outputDirectory, indices, keepAll(predicates...), convertImages)
+	if extractErr, ok := err.(*ExtractError); ok {
+		for _, v := range extractErr.Errors {
+			var convertErr *ConvertError
+			if errors.As(v, &convertErr) {
+				fmt.Fprintf(os.Stdout, "(Warning) %v\n", v)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "(Error) Failed to extract %v\n", v)
 		}
-
-		file.Close()
 	}
 	fmt.Println("Done.")
 }
 
+// Returns a predicate which keeps the indices whose file path matches the glob pattern.
+// If include is false, the matching indices are dropped instead; invalid patterns are fatal.
+func matchPattern(pattern string, include bool) func(ArchiveIndex) bool {
+	if _, err := path.Match(pattern, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "(Error) Invalid pattern %s: %v\n", pattern, err)
+		os.Exit(1)
+	}
+	return func(index ArchiveIndex) bool {
+		matched, _ := path.Match(pattern, index.FilePath)
+		return matched == include
+	}
+}
+
 func containsArgument(arguments []string, arg string) bool {
 	for _, v := range arguments {
 		if strings.ToLower(v) == strings.ToLower(arg) {
@@ -100,4 +255,15 @@ func containsArgument(arguments []string, arg string) bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// Returns the value following the specified argument.
+// The last argument is the archive path and is never returned as a value.
+func argumentValue(arguments []string, arg string) (string, bool) {
+	for i, v := range arguments[:len(arguments) - 1] {
+		if strings.ToLower(v) == strings.ToLower(arg) && i + 1 < len(arguments) - 1 {
+			return arguments[i + 1], true
+		}
+	}
+	return "", false
+}
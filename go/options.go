@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// Represents an option which changes how an RPA archive is parsed.
+type ArchiveOption func(options *archiveOptions)
+
+// Contains the settings which can be changed by passing archive options.
+type archiveOptions struct {
+	bestEffort bool
+	strict bool
+	keySalt int
+	readAttempts int
+	readBackoff time.Duration
+}
+
+// Skips malformed file indices instead of failing to parse the whole archive.
+// The errors of the skipped indices are stored in the Errors field of the archive.
+func WithBestEffort() ArchiveOption {
+	return func(options *archiveOptions) {
+		options.bestEffort = true
+	}
+}
+
+// Returns an error instead of a warning if the archive header is not recognized or the archive looks invalid.
+func WithStrict() ArchiveOption {
+	return func(options *archiveOptions) {
+		options.strict = true
+	}
+}
+
+// Applies a salt to the key used for the deobfuscation of RPA-3.0 archives.
+// Some forked Ren'Py distributions XOR a fixed salt into the key read from the archive header;
+// the salt is XORed into the derived key to reproduce the key used by the game.
+// The salt can usually be found in the archive loader of the game (renpy/loader.py or a custom archive handler),
+// where the additional XOR is applied to the key parsed from the header.
+// A salt of zero leaves the key unchanged.
+func WithKeySalt(salt int) ArchiveOption {
+	return func(options *archiveOptions) {
+		options.keySalt = salt
+	}
+}
+
+// Retries failed reads from the archive, e.g. for archives read from a network-backed reader.
+// A read is attempted up to the specified amount of times; the delay between two attempts starts at backoff
+// and is doubled after every attempt. Reads failing because of the end of the archive are not retried.
+func WithReadRetry(attempts int, backoff time.Duration) ArchiveOption {
+	return func(options *archiveOptions) {
+		options.readAttempts = attempts
+		options.readBackoff = backoff
+	}
+}
+
+// Wraps the reader according to the read settings.
+func (options archiveOptions) wrapReader(reader io.ReaderAt) io.ReaderAt {
+	if options.readAttempts <= 1 {
+		return reader
+	}
+	return &retryReaderAt{reader, options.readAttempts, options.readBackoff}
+}
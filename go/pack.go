@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// The name of the file containing the concatenated file contents of a packed archive.
+const packDataName = "data.bin"
+
+// The name of the file mapping the file paths to their location in the packed data.
+const packManifestName = "manifest.json"
+
+// Describes the location of a single file in the packed data.
+type PackEntry struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// Writes the contents of all files in the archive concatenated into a single data file in the specified directory.
+// Additionally a JSON manifest is written which maps every relative file path to its offset and length in the data file.
+// The directory must already exist; existing data and manifest files are overwritten.
+// Fails if the archive contains a file path more than once, as the manifest could only describe one of the files.
+func (archive *Archive) Pack(directory string) error {
+	file, err := os.Create(filepath.Join(directory, packDataName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Stream every file into the data file while recording its location.
+	writer := bufio.NewWriter(file)
+	manifest := make(map[string]PackEntry, len(archive.Indices))
+	var offset int64
+	for _, v := range archive.Indices {
+		if _, ok := manifest[v.FilePath]; ok {
+			return fmt.Errorf("%s: duplicate file path", v.FilePath)
+		}
+
+		written, err := archive.WriteIndexTo(writer, &v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", v.FilePath, err)
+		}
+		manifest[v.FilePath] = PackEntry{offset, written}
+		offset += written
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	// Write the manifest next to the data file.
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(directory, packManifestName), data, 0644)
+}
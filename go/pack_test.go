@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPack(t *testing.T) {
+	files := testFiles()
+	archive, err := NewArchive(writeTestArchive(t, files, WithPadding(1, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	directory := t.TempDir()
+	err = archive.Pack(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read every file back through the manifest.
+	data, err := os.ReadFile(filepath.Join(directory, packDataName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := os.ReadFile(filepath.Join(directory, packManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest map[string]PackEntry
+	err = json.Unmarshal(encoded, &manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest) != len(archive.Indices) {
+		t.Fatalf("expected %d manifest entries, got %d", len(archive.Indices), len(manifest))
+	}
+
+	// Every file rebuilt from the manifest matches the contents read directly from the archive.
+	var size int64
+	for i := range archive.Indices {
+		index := &archive.Indices[i]
+		entry, ok := manifest[index.FilePath]
+		if !ok {
+			t.Fatalf("%s: missing manifest entry", index.FilePath)
+		}
+		if entry.Offset < 0 || entry.Offset + entry.Length > int64(len(data)) {
+			t.Fatalf("%s: entry out of range", index.FilePath)
+		}
+		expected, err := archive.Read(index)
+		if err != nil {
+			t.Fatalf("%s: %v", index.FilePath, err)
+		}
+		if !bytes.Equal(data[entry.Offset:entry.Offset + entry.Length], expected) {
+			t.Fatalf("%s: contents differ", index.FilePath)
+		}
+		size += entry.Length
+	}
+	if size != int64(len(data)) {
+		t.Fatalf("expected %d bytes of data, got %d", size, len(data))
+	}
+}
+
+func TestPackDuplicatePath(t *testing.T) {
+	files := append(testFiles(), WriterFile{FilePath: "script.rpyc", Data: []byte("OTHER")})
+	archive, err := NewArchive(writeTestArchive(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	err = archive.Pack(t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "script.rpyc: duplicate file path") {
+		t.Fatalf("expected duplicate path error, got %v", err)
+	}
+}
@@ -8,23 +8,90 @@ import (
 	"github.com/golang-collections/collections/stack"
 	"io"
 	"os"
+	"unicode/utf8"
 )
 
 var unicodeString byte = 'X'
 var shortBinaryString byte = 'U'
 var binaryInteger byte = 'J'
+var binaryInteger1 byte = 'K'
+var binaryInteger2 byte = 'M'
 var binaryLong byte = 0x8A
 var binaryInput byte = 'q'
 var longBinaryInput byte = 'r'
+var binaryGet byte = 'h'
+var longBinaryGet byte = 'j'
 var endIndexPrefix byte = 0x87
 var endIndex byte = 0x86
+var emptyList byte = ']'
+var emptyDictionary byte = '}'
+var mark byte = '('
+var appendElement byte = 'a'
+var appendElements byte = 'e'
+var setItem byte = 's'
+var setItems byte = 'u'
 
+// Placeholders which are pushed to the stack instead of the containers built by the pickle.
+// They keep the memo indices aligned with the stored objects without building the containers.
+type pickleList struct{}
+type pickleDictionary struct{}
+type pickleTuple struct{}
+type pickleMark struct{}
+
+// Represents the source a pickle is read from.
+// Len returns the amount of unread bytes and is used to reject lengths exceeding the remaining pickle.
+type pickleReader interface {
+	io.Reader
+	io.ByteReader
+	io.Seeker
+	Len() int
+}
+
+// Contains the state of the pickle parser while reading the archive indices.
+type unpickler struct {
+	reader pickleReader
+	elementStack *stack.Stack
+	memo map[int]interface{}
+	// Receives every parsed index; an error returned by it stops the parser and is stored in stop.
+	emit func(ArchiveIndex) error
+	stop error
+}
+
+// Parses the archive indices from the specified pickle.
+// The function fails if any of the indices is malformed.
 func Unpickle(data []byte) ([]ArchiveIndex, error) {
-	// Prepare an empty slice of archive indices.
+	indices, _, err := unpickle(bytes.NewReader(data), false)
+	return indices, err
+}
+
+// Parses the archive indices from the specified pickle and skips malformed indices.
+// Returns the successfully parsed indices and the errors of the skipped indices.
+// An error is only returned if the pickle itself is invalid or not supported.
+func UnpickleBestEffort(data []byte) ([]ArchiveIndex, []error, error) {
+	return unpickle(bytes.NewReader(data), true)
+}
+
+// Parses the archive indices from the specified pickle and calls fn with every index in the order of the pickle.
+// In contrast to Unpickle no slice containing all indices is allocated. Parsing stops at the first error returned by fn.
+// If bestEffort is set, malformed indices are skipped and their errors are returned.
+func UnpickleFunc(data []byte, bestEffort bool, fn func(ArchiveIndex) error) ([]error, error) {
+	return unpickleFunc(bytes.NewReader(data), bestEffort, fn)
+}
+
+func unpickle(reader pickleReader, bestEffort bool) ([]ArchiveIndex, []error, error) {
 	var indices []ArchiveIndex
+	errs, err := unpickleFunc(reader, bestEffort, func(index ArchiveIndex) error {
+		indices = append(indices, index)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return indices, errs, nil
+}
 
+func unpickleFunc(reader pickleReader, bestEffort bool, fn func(ArchiveIndex) error) ([]error, error) {
 	// Validate pickle identifier and pickle version.
-	reader := bytes.NewReader(data)
 	protocolIdentifier, err := reader.ReadByte()
 	if err != nil {
 		return nil, err
@@ -37,12 +104,10 @@ func Unpickle(data []byte) ([]ArchiveIndex, error) {
 		return nil, errors.New("specified pickle is invalid or not supported")
 	}
 
-	// Skip the next four bytes as their not relevant for parsing the pickle.
-	reader.Seek(4, 1)
-
 	// Prepare a new stack to store values.
-	elementStack := stack.New()
-	var skipElement bool
+	state := &unpickler{reader: reader, elementStack: stack.New(), memo: make(map[int]interface{}), emit: fn}
+	var errs []error
+	var recovering bool
 	for {
 		// Read next marker byte and check for end of file.
 		b, err := reader.ReadByte()
@@ -52,122 +117,279 @@ func Unpickle(data []byte) ([]ArchiveIndex, error) {
 			return nil, err
 		}
 
-		if skipElement {
-			if b != endIndexPrefix && b != endIndex && b != ']' {
-				continue
-			} else if b == ']' {
-				skipElement = false
-				reader.Seek(2, 1)
+		// Skip to the beginning of the next index after a malformed index.
+		if recovering {
+			if b != unicodeString || !isIndexStart(reader) {
 				continue
 			}
+			recovering = false
 		}
 
-		skipElement = false
-		switch b {
-		case unicodeString:
-			// Read length prefix to determine string length.
-			length, err := readInteger(reader)
-			if err != nil {
+		err = state.readElement(b)
+		if state.stop != nil {
+			return nil, state.stop
+		}
+		if err != nil {
+			if !bestEffort {
 				return nil, err
 			}
 
-			// Create buffer to fit the string into.
-			buffer := make([]byte, length)
-			bytesRead, err := reader.Read(buffer)
-			if err != nil {
-				return nil, err
-			} else if bytesRead != int(length) {
-				return nil, errors.New("insufficient bytes left in stream")
-			}
-			// Push element to stack.
-			elementStack.Push(buffer)
-			skipElement = true
-		case binaryLong:
-			// Read length of integer.
-			length, err := reader.ReadByte()
-			if err != nil {
-				return nil, err
-			}
+			// Record the error and discard the values of the malformed index.
+			position, _ := reader.Seek(0, 1)
+			errs = append(errs, fmt.Errorf("%v (at mem-pos: %d)", err, position))
+			state.elementStack = stack.New()
+			recovering = true
+		}
+	}
 
-			// Create buffer to fit in integer.
-			buffer := make([]byte, length)
-			_, err = reader.Read(buffer)
-			var number int
-			switch length {
-			case 4:
-				number = int(binary.LittleEndian.Uint32(buffer))
-			case 8:
-				number = int(binary.LittleEndian.Uint64(buffer))
-			default:
-				return nil, errors.New(fmt.Sprintf("%d is not a valid binary input length", length))
-			}
-			// Push element to stack.
-			elementStack.Push(number)
-		case binaryInteger:
-			number, err := readInteger(reader)
-			if err != nil {
-				return nil, err
-			}
-			// Push element to stack.
-			elementStack.Push(number)
-		case shortBinaryString:
-			length, err := reader.ReadByte()
-			if err != nil {
-				return nil, err
-			}
+	return errs, nil
+}
 
-			buffer := make([]byte, length)
-			readBytes, err := reader.Read(buffer)
-			if err != nil {
-				return nil, err
-			} else if readBytes != int(length) {
-				return nil, errors.New("insufficient bytes left in stream")
-			}
-			elementStack.Push(buffer)
-		case longBinaryInput:
-			reader.Seek(4, 1)
-		case binaryInput:
-			reader.Seek(1, 1)
-		case endIndex, endIndexPrefix:
-			var prefixObject interface{}
-			if b == endIndexPrefix {
-				prefixObject = elementStack.Pop()
+// Returns a value indicating whether the reader is positioned after the marker byte of a file path.
+// A file path is a non-empty unicode string which is stored in the memo and followed by the list of its tuples.
+// The position of the reader is not changed.
+func isIndexStart(reader pickleReader) bool {
+	position, err := reader.Seek(0, 1)
+	if err != nil {
+		return false
+	}
+	defer reader.Seek(position, 0)
+
+	length, err := readInteger(reader)
+	if err != nil || length <= 0 || int64(length) > int64(reader.Len()) {
+		return false
+	}
+	path := make([]byte, length)
+	err = readFull(reader, path)
+	if err != nil || !utf8.Valid(path) {
+		return false
+	}
+
+	// Skip the memo index of the file path.
+	b, err := reader.ReadByte()
+	switch {
+	case err == nil && b == binaryInput:
+		_, err = reader.Seek(1, 1)
+	case err == nil && b == longBinaryInput:
+		_, err = reader.Seek(4, 1)
+	default:
+		return false
+	}
+	if err != nil {
+		return false
+	}
+	b, err = reader.ReadByte()
+	return err == nil && b == emptyList
+}
+
+// Reads the element identified by the specified marker byte and updates the parser state.
+func (state *unpickler) readElement(b byte) error {
+	switch b {
+	case unicodeString:
+		// Read length prefix to determine string length.
+		length, err := readInteger(state.reader)
+		if err != nil {
+			return err
+		}
+
+		// Check if the string fits into the remaining stream.
+		if length < 0 || int64(length) > int64(state.reader.Len()) {
+			return errors.New("binary: truncated pickle")
+		}
+
+		// Create buffer to fit the string into.
+		buffer := make([]byte, length)
+		err = readFull(state.reader, buffer)
+		if err != nil {
+			return err
+		}
+		// Push element to stack.
+		state.elementStack.Push(buffer)
+	case binaryLong:
+		// Read length of integer.
+		length, err := state.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		// The integer is stored as little endian two's complement; only integers fitting into 64 bits are supported.
+		if length > 8 {
+			return fmt.Errorf("%d is not a valid binary long length", length)
+		}
+
+		// Create buffer to fit in integer.
+		buffer := make([]byte, length)
+		err = readFull(state.reader, buffer)
+		if err != nil {
+			return err
+		}
+
+		// Push element to stack.
+		state.elementStack.Push(decodeLong(buffer))
+	case binaryInteger:
+		number, err := readInteger(state.reader)
+		if err != nil {
+			return err
+		}
+		// Push element to stack.
+		state.elementStack.Push(number)
+	case binaryInteger1:
+		number, err := state.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		// Push element to stack.
+		state.elementStack.Push(int(number))
+	case binaryInteger2:
+		buffer := make([]byte, 2)
+		err := readFull(state.reader, buffer)
+		if err != nil {
+			return err
+		}
+		// Push element to stack.
+		state.elementStack.Push(int(binary.LittleEndian.Uint16(buffer)))
+	case shortBinaryString:
+		length, err := state.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		buffer := make([]byte, length)
+		err = readFull(state.reader, buffer)
+		if err != nil {
+			return err
+		}
+		state.elementStack.Push(buffer)
+	case longBinaryInput:
+		index, err := readInteger(state.reader)
+		if err != nil {
+			return err
+		}
+		// Store the element on top of the stack in the memo.
+		state.memo[int(index)] = state.elementStack.Peek()
+	case binaryInput:
+		index, err := state.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		// Store the element on top of the stack in the memo.
+		state.memo[int(index)] = state.elementStack.Peek()
+	case longBinaryGet:
+		index, err := readInteger(state.reader)
+		if err != nil {
+			return err
+		}
+		// Push memoized element to stack.
+		return state.pushMemo(int(index))
+	case binaryGet:
+		index, err := state.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		// Push memoized element to stack.
+		return state.pushMemo(int(index))
+	case emptyList:
+		// Push placeholder so the memo stores the list and not the file path below it.
+		state.elementStack.Push(pickleList{})
+	case emptyDictionary:
+		state.elementStack.Push(pickleDictionary{})
+	case mark:
+		state.elementStack.Push(pickleMark{})
+	case appendElement:
+		// Remove the appended tuple; the list itself is not needed.
+		state.elementStack.Pop()
+	case appendElements:
+		// Remove the tuples appended since the last mark.
+		for state.elementStack.Len() > 0 {
+			if _, ok := state.elementStack.Pop().(pickleMark); ok {
+				break
 			}
-			lengthObject := elementStack.Pop()
-			offsetObject := elementStack.Pop()
-			pathObject := elementStack.Pop()
-
-			if lengthObject == nil || offsetObject == nil || pathObject == nil || (b == endIndexPrefix && prefixObject == nil) {
-				// Push valid popped values back to stack.
-				if offsetObject != nil {
-					elementStack.Push(offsetObject)
-				}
-				if lengthObject != nil {
-					elementStack.Push(lengthObject)
-				}
-				if prefixObject != nil {
-					elementStack.Push(prefixObject)
-				}
-
-				position, _ := reader.Seek(0, 1)
-				fmt.Fprintf(os.Stdout, "(Warning) Failed to pop sufficient values from stack. (at mem-pos: %d)\n", position)
-				continue
+		}
+	case setItem:
+		// Remove the list and the file path of the dictionary item.
+		state.elementStack.Pop()
+		state.elementStack.Pop()
+	case setItems:
+		// Remove the lists and file paths of all dictionary items since the last mark.
+		for state.elementStack.Len() > 0 {
+			if _, ok := state.elementStack.Pop().(pickleMark); ok {
+				break
 			}
+		}
+	case endIndex, endIndexPrefix:
+		var prefixObject interface{}
+		if b == endIndexPrefix {
+			prefixObject = state.elementStack.Pop()
+		}
+		lengthObject := state.elementStack.Pop()
+		offsetObject := state.elementStack.Pop()
 
-			offset, err := castInteger(offsetObject)
-			if err != nil {
-				return nil, err
+		// The tuple is appended to the list which belongs to the file path below it.
+		// Both stay on the stack until the dictionary item is set, so a list can contain multiple tuples,
+		// which are either appended one by one or follow a mark when they are appended at once.
+		var above []interface{}
+		listObject := state.elementStack.Pop()
+		for {
+			_, isTuple := listObject.(pickleTuple)
+			_, isMark := listObject.(pickleMark)
+			if !isTuple && !isMark {
+				break
 			}
+			above = append(above, listObject)
+			listObject = state.elementStack.Pop()
+		}
+		pathObject := state.elementStack.Peek()
+		if listObject != nil {
+			state.elementStack.Push(listObject)
+		}
+		for i := len(above) - 1; i >= 0; i-- {
+			state.elementStack.Push(above[i])
+		}
+		if _, ok := listObject.(pickleList); !ok {
+			pathObject = nil
+		}
 
-			length, err := castInteger(lengthObject)
-			if err != nil {
-				return nil, err
-			}
+		// Push placeholder for the tuple, which is stored in the memo and appended to the list.
+		state.elementStack.Push(pickleTuple{})
 
-			indices = append(indices, ArchiveIndex{string(pathObject.([]byte)), int64(offset), int(length), prefixObject.([]byte)})
-			reader.Seek(3, 1)
+		if lengthObject == nil || offsetObject == nil || pathObject == nil || (b == endIndexPrefix && prefixObject == nil) {
+			position, _ := state.reader.Seek(0, 1)
+			fmt.Fprintf(os.Stdout, "(Warning) Failed to pop sufficient values from stack. (at mem-pos: %d)\n", position)
+			return nil
 		}
+
+		offset, err := castInteger(offsetObject)
+		if err != nil {
+			return err
+		}
+
+		length, err := castInteger(lengthObject)
+		if err != nil {
+			return err
+		}
+
+		// Validate the types of the path and the optional prefix.
+		path, ok := pathObject.([]byte)
+		if !ok {
+			return fmt.Errorf("binary: invalid type for file path: %v", pathObject)
+		}
+		prefix, ok := prefixObject.([]byte)
+		if !ok && prefixObject != nil {
+			return fmt.Errorf("binary: invalid type for file prefix: %v", prefixObject)
+		}
+
+		state.stop = state.emit(ArchiveIndex{string(path), int64(offset), int(length), prefix})
 	}
 
-	return indices, nil
-}
\ No newline at end of file
+	return nil
+}
+
+// Pushes the element stored at the specified memo index to the stack.
+func (state *unpickler) pushMemo(index int) error {
+	element, ok := state.memo[index]
+	if !ok {
+		return fmt.Errorf("binary: memo index %d is not defined", index)
+	}
+	state.elementStack.Push(element)
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Wraps a byte reader and returns at most one byte per call to Read.
+type shortReader struct {
+	*bytes.Reader
+}
+
+func (reader shortReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return reader.Reader.Read(p)
+}
+
+// Returns indices with strings, prefixes and integers of every supported size.
+func testIndices() []ArchiveIndex {
+	return []ArchiveIndex{
+		{"images/a.png", 0x33, 7, []byte{}},
+		{"images/sub/b.jpg", 0x1234, 300, []byte("JPG")},
+		{"script.rpyc", 0x12345678, 70000, []byte{}},
+		{"audio/ä.ogg", 0x7FFFFFFF, 1, []byte("prefix")},
+	}
+}
+
+func TestUnpickleShortReads(t *testing.T) {
+	indices := testIndices()
+	data := Pickle(indices)
+
+	expected, err := Unpickle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, indices) {
+		t.Fatalf("expected %v, got %v", indices, expected)
+	}
+
+	parsed, _, err := unpickle(shortReader{bytes.NewReader(data)}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, expected) {
+		t.Fatalf("expected %v, got %v", expected, parsed)
+	}
+}
+
+func TestUnpickleTruncated(t *testing.T) {
+	data := Pickle(testIndices())
+
+	// Cut the pickle within the first file path.
+	truncated := data[:bytes.Index(data, []byte("images/a"))+4]
+	for _, reader := range []pickleReader{bytes.NewReader(truncated), shortReader{bytes.NewReader(truncated)}} {
+		_, _, err := unpickle(reader, false)
+		if err == nil || !strings.Contains(err.Error(), "truncated pickle") {
+			t.Fatalf("expected truncated pickle error, got %v", err)
+		}
+	}
+}
+
+func TestReadIntegerShortReads(t *testing.T) {
+	number, err := readInteger(shortReader{bytes.NewReader([]byte{0x01, 0x02, 0x00, 0x80})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number != -0x7FFFFDFF {
+		t.Fatalf("unexpected value %d", number)
+	}
+
+	_, err = readInteger(shortReader{bytes.NewReader([]byte{0x01, 0x02})})
+	if err == nil || !strings.Contains(err.Error(), "truncated pickle") {
+		t.Fatalf("expected truncated pickle error, got %v", err)
+	}
+}
+
+// Returns a pickled file tree with two files where the prefix of the second file is read from the memo slot.
+func memoPickle(slot byte) []byte {
+	data := []byte{0x80, 2, '}', 'q', 0, '('}
+	data = append(data, 'X', 5, 0, 0, 0)
+	data = append(data, "a.png"...)
+	data = append(data, 'q', 1, ']', 'q', 2, 'K', 0x10, 'K', 0x20, 'U', 3)
+	data = append(data, "PNG"...)
+	data = append(data, 'q', 3, 0x87, 'q', 4, 'a')
+	data = append(data, 'X', 5, 0, 0, 0)
+	data = append(data, "b.png"...)
+	data = append(data, 'q', 5, ']', 'q', 6, 'K', 0x30, 'K', 0x40, 'h', slot, 0x87, 'q', 7, 'a', 'u', '.')
+	return data
+}
+
+func TestUnpickleMemo(t *testing.T) {
+	indices, err := Unpickle(memoPickle(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ArchiveIndex{{"a.png", 0x10, 0x20, []byte("PNG")}, {"b.png", 0x30, 0x40, []byte("PNG")}}
+	if !reflect.DeepEqual(indices, expected) {
+		t.Fatalf("expected %v, got %v", expected, indices)
+	}
+
+	// The memo slots of the dictionary, the list and the tuple must not contain the file path or the prefix below them.
+	for _, slot := range []byte{0, 2, 4} {
+		_, err := Unpickle(memoPickle(slot))
+		if err == nil || !strings.Contains(err.Error(), "invalid type for file prefix") {
+			t.Fatalf("slot %d: expected invalid prefix type, got %v", slot, err)
+		}
+	}
+}
+
+// Replaces the first occurrence of old in the pickle with the malformed bytes.
+func corruptPickle(t *testing.T, data, old, malformed []byte) []byte {
+	position := bytes.Index(data, old)
+	if position < 0 {
+		t.Fatalf("%q not found in pickle", old)
+	}
+	return append(append(append([]byte{}, data[:position]...), malformed...), data[position+len(old):]...)
+}
+
+func TestUnpickleBestEffort(t *testing.T) {
+	// The offset of the second file is stored as BININT1 0x58, which is also the marker byte of a file path.
+	indices := testIndices()
+	indices[1].Offset = int64(unicodeString)
+	expected := []ArchiveIndex{indices[0], indices[2], indices[3]}
+	path := append([]byte{unicodeString, 16, 0, 0, 0}, indices[1].FilePath...)
+
+	tests := []struct {
+		name string
+		malformed []byte
+	}{
+		{"invalid prefix type", corruptPickle(t, Pickle(indices), []byte("U\x03JPG"), []byte{binaryInteger1, 1})},
+		{"contains marker byte", corruptPickle(t, Pickle(indices), path, append([]byte{unicodeString, 0xFF, 0xFF, 0xFF, 0x7F}, indices[1].FilePath...))},
+	}
+	for _, v := range tests {
+		t.Run(v.name, func(t *testing.T) {
+			if _, err := Unpickle(v.malformed); err == nil {
+				t.Fatal("expected error for malformed index")
+			}
+
+			parsed, errs, err := UnpickleBestEffort(v.malformed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(errs) != 1 {
+				t.Fatalf("expected one error, got %v", errs)
+			}
+			if !reflect.DeepEqual(parsed, expected) {
+				t.Fatalf("expected %v, got %v", expected, parsed)
+			}
+		})
+	}
+}
+
+func TestDecodeLong(t *testing.T) {
+	tests := []struct {
+		buffer []byte
+		expected int64
+	}{
+		{[]byte{}, 0},
+		{[]byte{0x7F}, 0x7F},
+		{[]byte{0xFF}, -1},
+		{[]byte{0x00, 0x80}, -0x8000},
+		{[]byte{0xEF, 0xBE, 0xAD, 0xDE, 0x00}, 0xDEADBEEF},
+		{[]byte{0xEF, 0xBE, 0xAD, 0xDE}, -0x21524111},
+		{[]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07}, 0x07060504030201},
+		{[]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F}, 0x7FFFFFFFFFFFFFFF},
+		{[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80}, -0x8000000000000000},
+	}
+	for _, v := range tests {
+		if number := decodeLong(v.buffer); number != v.expected {
+			t.Fatalf("%x: expected %d, got %d", v.buffer, v.expected, number)
+		}
+	}
+}
+
+func TestUnpickleLong(t *testing.T) {
+	// Python stores 0xdeadbeef as a LONG1 of five bytes; the other offsets use every length up to 8 bytes.
+	indices := []ArchiveIndex{{"a", 0xDEADBEEF, 1, []byte{}}, {"b", -0x80000001, 2, []byte{}}, {"c", 0x123456789ABC, 3, []byte{}}, {"d", 0x7FFFFFFFFFFFFFFF, 4, []byte{}}}
+	data := Pickle(indices)
+	if !bytes.Contains(data, []byte{binaryLong, 5, 0xEF, 0xBE, 0xAD, 0xDE, 0x00}) {
+		t.Fatal("expected five byte LONG1 in pickle")
+	}
+	parsed, err := Unpickle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, indices) {
+		t.Fatalf("expected %v, got %v", indices, parsed)
+	}
+
+	// Integers longer than 8 bytes are rejected.
+	malformed := corruptPickle(t, data, []byte{binaryLong, 5, 0xEF, 0xBE, 0xAD, 0xDE, 0x00}, []byte{binaryLong, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	_, err = Unpickle(malformed)
+	if err == nil || !strings.Contains(err.Error(), "9 is not a valid binary long length") {
+		t.Fatalf("expected invalid length error, got %v", err)
+	}
+}
+
+func TestUnpicklePython(t *testing.T) {
+	// Pickles written by pickle.dumps(tree, 2) of CPython.
+	tests := []struct {
+		name string
+		pickle string
+		expected []ArchiveIndex
+	}{
+		{"empty", "80027d71002e", nil},
+		// A dictionary with a single item is set with SETITEM and has no mark.
+		{"single file", "80027d71005805000000612e706e6771015d71024b104b205800000000710387710461732e",
+			[]ArchiveIndex{{"a.png", 0x10, 0x20, []byte{}}}},
+		{"two files", "80027d7100285805000000612e706e6771015d71024b104b205803000000504e477103877104615805000000622e706e6771055d71064b304b405800000000710787710861752e",
+			[]ArchiveIndex{{"a.png", 0x10, 0x20, []byte("PNG")}, {"b.png", 0x30, 0x40, []byte{}}}},
+		// A list with multiple tuples is appended with APPENDS after a mark.
+		{"two tuples", "80027d71005805000000612e706e6771015d7102284b104b20580000000071038771044b504b60680387710565732e",
+			[]ArchiveIndex{{"a.png", 0x10, 0x20, []byte{}}, {"a.png", 0x50, 0x60, []byte{}}}},
+	}
+	for _, v := range tests {
+		data, err := hex.DecodeString(v.pickle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		indices, err := Unpickle(data)
+		if err != nil {
+			t.Fatalf("%s: %v", v.name, err)
+		}
+		if !reflect.DeepEqual(indices, v.expected) {
+			t.Fatalf("%s: expected %v, got %v", v.name, v.expected, indices)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// Provides streaming access to the contents of a single file located within an archive.
+// The prefix stored in the index is read from memory, the remaining body is read from the archive.
+type indexReader struct {
+	prefix []byte
+	body *io.SectionReader
+	position int64
+	length int64
+}
+
+// Reads up to len(p) bytes from the current position within the file.
+func (reader *indexReader) Read(p []byte) (int, error) {
+	if reader.position >= reader.length {
+		return 0, io.EOF
+	}
+
+	// Read from the prefix if the position is located within it.
+	prefixLength := int64(len(reader.prefix))
+	if reader.position < prefixLength {
+		n := copy(p, reader.prefix[reader.position:])
+		reader.position += int64(n)
+		return n, nil
+	}
+
+	// Read from the body on disk.
+	n, err := reader.body.ReadAt(p, reader.position - prefixLength)
+	reader.position += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Sets the position for the next read within the file.
+// The position may be moved anywhere within the file including back into the prefix.
+func (reader *indexReader) Seek(offset int64, whence int) (int64, error) {
+	var position int64
+	switch whence {
+	case io.SeekStart:
+		position = offset
+	case io.SeekCurrent:
+		position = reader.position + offset
+	case io.SeekEnd:
+		position = reader.length + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if position < 0 {
+		return 0, errors.New("negative position")
+	}
+	reader.position = position
+	return position, nil
+}
+
+// Closes the reader. The file handle of the archive stays open.
+func (reader *indexReader) Close() error {
+	return nil
+}
+
+// Opens the specified file from the archive for streaming access.
+// The returned reader supports seeking, which allows it to be passed to decoders like png.Decode directly.
+// The reader must not be used after the archive has been closed.
+func (archive *Archive) OpenIndex(index *ArchiveIndex) (io.ReadSeekCloser, error) {
+	// Check if file exists and is loaded.
+	if !archive.hasIndex(index) {
+		return nil, errors.New("index cannot be nil and must be valid")
+	}
+
+	// Open archive file in read-only mode.
+	err := archive.open()
+	if err != nil {
+		return nil, err
+	}
+
+	body := io.NewSectionReader(archive.handle, index.Offset, int64(index.Length - len(index.Prefix)))
+	return &indexReader{prefix: index.Prefix, body: body, length: int64(index.Length)}, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func ExampleArchive_OpenIndex() {
+	// Write an archive containing a PNG image whose signature is stored as prefix.
+	img := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	img.Set(1, 2, color.RGBA{0xFF, 0x80, 0x00, 0xFF})
+	var encoded bytes.Buffer
+	png.Encode(&encoded, img)
+
+	directory, _ := os.MkdirTemp("", "rpaextract")
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "images.rpa")
+	file, _ := os.Create(path)
+	WriteArchive(file, []WriterFile{{FilePath: "images/icon.png", Data: encoded.Bytes(), PrefixLength: 8}})
+	file.Close()
+
+	// Decode the image straight from the archive.
+	archive, err := NewArchive(path)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer archive.Close()
+	reader, err := archive.OpenIndex(&archive.Indices[0])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer reader.Close()
+
+	decoded, err := png.Decode(reader)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	r, g, b, _ := decoded.At(1, 2).RGBA()
+	fmt.Println(decoded.Bounds().Size(), r >> 8, g >> 8, b >> 8)
+	// Output: (4,3) 255 128 0
+}
+
+func TestIndexReaderSeek(t *testing.T) {
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	archive, index := openPrefixArchive(t, data, 10)
+	reader, err := archive.OpenIndex(&index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// Read into the body before seeking back.
+	buffer := make([]byte, 20)
+	_, err = io.ReadFull(reader, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		offset int64
+		whence int
+		position int64
+	}{
+		{"back into prefix", -17, io.SeekCurrent, 3},
+		{"start of prefix", 0, io.SeekStart, 0},
+		{"end of prefix", 9, io.SeekStart, 9},
+		{"body from end", -6, io.SeekEnd, 30},
+		{"prefix from end", -30, io.SeekEnd, 6},
+		{"boundary", 10, io.SeekStart, 10},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			position, err := reader.Seek(c.offset, c.whence)
+			if err != nil || position != c.position {
+				t.Fatalf("expected position %d, got %d (%v)", c.position, position, err)
+			}
+			read, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(read, data[c.position:]) {
+				t.Fatalf("expected %q, got %q", data[c.position:], read)
+			}
+			// Move back to the position after the first read.
+			reader.Seek(20, io.SeekStart)
+		})
+	}
+
+	// Seeking before the start of the file fails.
+	if _, err := reader.Seek(-21, io.SeekCurrent); err == nil {
+		t.Fatal("expected error for negative position")
+	}
+}
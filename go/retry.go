@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// Wraps a reader and retries failed reads with an exponential backoff.
+type retryReaderAt struct {
+	reader io.ReaderAt
+	attempts int
+	backoff time.Duration
+}
+
+// Reads len(p) bytes starting at the offset off.
+// If a read fails with a transient error, the remaining bytes are read again after a delay.
+func (reader *retryReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	read := 0
+	delay := reader.backoff
+	for attempt := 1; ; attempt++ {
+		n, err := reader.reader.ReadAt(p[read:], off + int64(read))
+		read += n
+		if read == len(p) && err != io.EOF {
+			return read, nil
+		}
+		if err == nil || off < 0 || !isTransient(err) || attempt >= reader.attempts {
+			return read, err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Returns a value indicating whether the read error may disappear when the read is retried.
+// Reaching the end of the archive or reading from a closed file is never transient.
+func isTransient(err error) bool {
+	return !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, os.ErrClosed)
+}
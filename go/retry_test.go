@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+var errFlaky = errors.New("flaky: connection reset")
+
+// Wraps a reader and fails the first reads with a transient error.
+// A failing read returns half of the requested bytes before the error if partial is set.
+type flakyReaderAt struct {
+	reader io.ReaderAt
+	failures int
+	partial bool
+	reads int
+}
+
+func (reader *flakyReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	reader.reads++
+	if reader.reads > reader.failures {
+		return reader.reader.ReadAt(p, off)
+	}
+	if !reader.partial {
+		return 0, errFlaky
+	}
+	n, _ := reader.reader.ReadAt(p[:len(p) / 2], off)
+	return n, errFlaky
+}
+
+func TestRetryReaderAt(t *testing.T) {
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	tests := []struct {
+		name string
+		failures int
+		partial bool
+		attempts int
+		err error
+	}{
+		{"no failures", 0, false, 3, nil},
+		{"recovers", 2, false, 3, nil},
+		{"recovers after partial reads", 2, true, 3, nil},
+		{"too many failures", 3, false, 3, errFlaky},
+		{"no retry", 1, false, 1, errFlaky},
+	}
+	for _, v := range tests {
+		t.Run(v.name, func(t *testing.T) {
+			flaky := &flakyReaderAt{reader: bytes.NewReader(data), failures: v.failures, partial: v.partial}
+			reader := &retryReaderAt{flaky, v.attempts, time.Microsecond}
+
+			buffer := make([]byte, 20)
+			n, err := reader.ReadAt(buffer, 5)
+			if err != v.err {
+				t.Fatalf("expected error %v, got %v", v.err, err)
+			}
+			if err == nil && (n != len(buffer) || !bytes.Equal(buffer, data[5:25])) {
+				t.Fatalf("expected %q, got %q", data[5:25], buffer[:n])
+			}
+		})
+	}
+}
+
+func TestRetryReaderAtEOF(t *testing.T) {
+	// Reads beyond the end of the data are not retried.
+	flaky := &flakyReaderAt{reader: bytes.NewReader([]byte("0123456789"))}
+	reader := &retryReaderAt{flaky, 5, time.Microsecond}
+	buffer := make([]byte, 8)
+	n, err := reader.ReadAt(buffer, 5)
+	if err != io.EOF || n != 5 || flaky.reads != 1 {
+		t.Fatalf("expected EOF after 5 bytes and one read, got %v after %d bytes and %d reads", err, n, flaky.reads)
+	}
+}
+
+func TestNewArchiveWithReadRetry(t *testing.T) {
+	var buffer bytes.Buffer
+	files := testFiles()
+	err := WriteArchive(&buffer, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+
+	// The first read of the header fails, which is fatal without retrying.
+	_, err = NewArchiveFromReaderAt(&flakyReaderAt{reader: bytes.NewReader(data), failures: 1}, int64(len(data)))
+	if err == nil {
+		t.Fatal("expected error without retrying")
+	}
+
+	archive, err := NewArchiveFromReaderAt(&flakyReaderAt{reader: bytes.NewReader(data), failures: 2, partial: true}, int64(len(data)), WithReadRetry(3, time.Microsecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertArchiveContents(t, archive, files)
+}
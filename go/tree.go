@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Represents a directory or a file in the directory tree of an archive.
+type TreeNode struct {
+	Name string
+	IsDir bool
+	// Contains the amount of files located in the directory including all sub-directories.
+	FileCount int
+	// Contains the sorted child nodes of a directory; directories are sorted before files.
+	Children []*TreeNode
+	lookup map[string]*TreeNode
+}
+
+// Builds a directory tree from the specified slash-separated relative file paths.
+// Returns the root directory of the tree, which has an empty name.
+func BuildTree(paths []string) *TreeNode {
+	root := &TreeNode{IsDir: true}
+	for _, v := range paths {
+		parts := strings.Split(strings.Trim(v, "/"), "/")
+		node := root
+		for i, name := range parts {
+			node.FileCount++
+			isDir := i < len(parts) - 1
+			node = node.child(name, isDir)
+		}
+	}
+	root.sort()
+	return root
+}
+
+// Returns the child node with the specified name and creates it if necessary.
+func (node *TreeNode) child(name string, isDir bool) *TreeNode {
+	if node.lookup == nil {
+		node.lookup = make(map[string]*TreeNode)
+	}
+
+	// Directories and files with the same name are kept apart.
+	key := name
+	if isDir {
+		key += "/"
+	}
+	child, ok := node.lookup[key]
+	if !ok {
+		child = &TreeNode{Name: name, IsDir: isDir}
+		node.lookup[key] = child
+		node.Children = append(node.Children, child)
+	}
+	return child
+}
+
+// Sorts the child nodes recursively and releases the lookup tables used while building the tree.
+func (node *TreeNode) sort() {
+	node.lookup = nil
+	sort.Slice(node.Children, func(i, j int) bool {
+		a, b := node.Children[i], node.Children[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		return a.Name < b.Name
+	})
+	for _, v := range node.Children {
+		v.sort()
+	}
+}
+
+// Merges every directory whose only child is another directory with that child, e.g. "a" and "b" become "a/b".
+// The root directory itself is never merged.
+func (node *TreeNode) Collapse() {
+	for _, v := range node.Children {
+		for v.IsDir && len(v.Children) == 1 && v.Children[0].IsDir {
+			only := v.Children[0]
+			v.Name += "/" + only.Name
+			v.Children = only.Children
+		}
+		v.Collapse()
+	}
+}
+
+// Writes the directory tree as an indented listing to the writer.
+// Directories are marked with a trailing slash and show the amount of files they contain.
+func WriteTree(writer io.Writer, root *TreeNode) error {
+	return writeTreeLevel(writer, root, 0)
+}
+
+// Writes the child nodes of the specified node at the specified indentation level.
+func writeTreeLevel(writer io.Writer, node *TreeNode, level int) error {
+	indent := strings.Repeat("  ", level)
+	for _, v := range node.Children {
+		var err error
+		if v.IsDir {
+			_, err = fmt.Fprintf(writer, "%s%s/ (%d files)\n", indent, v.Name, v.FileCount)
+		} else {
+			_, err = fmt.Fprintf(writer, "%s%s\n", indent, v.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = writeTreeLevel(writer, v, level + 1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
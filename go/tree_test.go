@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Returns the paths of a small game used to build the test trees.
+func treePaths() []string {
+	return []string{
+		"game/script.rpyc",
+		"game/images/bg/b.png",
+		"readme.txt",
+		"game/images/c.png",
+		"deep/x/y/file.bin",
+		"game/audio/music/theme.ogg",
+		"game/images/bg/a.png",
+	}
+}
+
+// Returns the listing of the tree written by WriteTree.
+func writeTestTree(t *testing.T, root *TreeNode) string {
+	t.Helper()
+	var buffer bytes.Buffer
+	err := WriteTree(&buffer, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.String()
+}
+
+func TestBuildTree(t *testing.T) {
+	root := BuildTree(treePaths())
+	expected := `deep/ (1 files)
+  x/ (1 files)
+    y/ (1 files)
+      file.bin
+game/ (5 files)
+  audio/ (1 files)
+    music/ (1 files)
+      theme.ogg
+  images/ (3 files)
+    bg/ (2 files)
+      a.png
+      b.png
+    c.png
+  script.rpyc
+readme.txt
+`
+	if listing := writeTestTree(t, root); listing != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, listing)
+	}
+}
+
+func TestBuildTreeFileCount(t *testing.T) {
+	root := BuildTree(treePaths())
+	if root.Name != "" || !root.IsDir || root.FileCount != 7 {
+		t.Fatalf("unexpected root %+v", root)
+	}
+
+	// Every directory counts the files of all its sub-directories.
+	counts := map[string]int{"deep": 1, "game": 5}
+	for _, v := range root.Children {
+		if v.IsDir && v.FileCount != counts[v.Name] {
+			t.Fatalf("%s: expected %d files, got %d", v.Name, counts[v.Name], v.FileCount)
+		}
+		if !v.IsDir && (v.FileCount != 0 || len(v.Children) != 0) {
+			t.Fatalf("%s: unexpected file node %+v", v.Name, v)
+		}
+	}
+	images := root.Children[1].Children[1]
+	if images.Name != "images" || images.FileCount != 3 || images.Children[0].FileCount != 2 {
+		t.Fatalf("unexpected images directory %+v", images)
+	}
+}
+
+func TestBuildTreeDirectoryAndFile(t *testing.T) {
+	// A file and a directory with the same name are separate nodes; leading and trailing slashes are ignored.
+	root := BuildTree([]string{"docs", "/docs/a.txt", "docs/b.txt/"})
+	expected := `docs/ (2 files)
+  a.txt
+  b.txt
+docs
+`
+	if listing := writeTestTree(t, root); listing != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, listing)
+	}
+}
+
+func TestCollapse(t *testing.T) {
+	root := BuildTree(treePaths())
+	root.Collapse()
+	expected := `deep/x/y/ (1 files)
+  file.bin
+game/ (5 files)
+  audio/music/ (1 files)
+    theme.ogg
+  images/ (3 files)
+    bg/ (2 files)
+      a.png
+      b.png
+    c.png
+  script.rpyc
+readme.txt
+`
+	if listing := writeTestTree(t, root); listing != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, listing)
+	}
+
+	// Collapsing keeps the file counts of the merged directories.
+	if root.Children[0].FileCount != 1 || root.Children[1].Children[0].FileCount != 1 {
+		t.Fatal("unexpected file counts after collapsing")
+	}
+
+	// A directory containing only a file is not merged.
+	single := BuildTree([]string{"a/file.txt"})
+	single.Collapse()
+	if listing := writeTestTree(t, single); listing != "a/ (1 files)\n  file.txt\n" {
+		t.Fatalf("unexpected listing %q", listing)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+// Represents a file which is written into an RPA archive.
+type WriterFile struct {
+	FilePath string
+	Data []byte
+	// Contains the amount of leading bytes which are stored as prefix in the index instead of the archive body.
+	PrefixLength int
+}
+
+// Represents an option which changes how an RPA archive is written.
+type WriterOption func(options *writerOptions)
+
+// Contains the settings which can be changed by passing writer options.
+type writerOptions struct {
+	key int
+	minPadding int
+	maxPadding int
+}
+
+// Inserts between min and max random bytes of padding in front of every file body.
+// Ren'Py uses a few bytes of padding to make the file offsets harder to guess.
+func WithPadding(min, max int) WriterOption {
+	return func(options *writerOptions) {
+		options.minPadding = min
+		options.maxPadding = max
+	}
+}
+
+// Uses the specified key to obfuscate the offsets and lengths instead of a random key.
+func WithWriterKey(key int) WriterOption {
+	return func(options *writerOptions) {
+		options.key = key
+	}
+}
+
+// Writes an RPA-3.0 archive containing the specified files to the writer.
+// The file bodies follow the header in the order of the files, the pickled file tree is written last.
+func WriteArchive(writer io.Writer, files []WriterFile, options ...WriterOption) error {
+	settings := writerOptions{key: int(rand.Int31())}
+	for _, option := range options {
+		option(&settings)
+	}
+	if settings.minPadding < 0 || settings.maxPadding < settings.minPadding {
+		return errors.New("invalid padding range")
+	}
+
+	// Lay out the bodies after the header and record the location of every file.
+	var body bytes.Buffer
+	indices := make([]ArchiveIndex, len(files))
+	offset := int64(len(formatHeader(0, 0)))
+	for i, v := range files {
+		if v.PrefixLength < 0 || v.PrefixLength > len(v.Data) || v.PrefixLength > 255 {
+			return fmt.Errorf("%s: invalid prefix length", v.FilePath)
+		}
+
+		padding := make([]byte, settings.minPadding + rand.Intn(settings.maxPadding - settings.minPadding + 1))
+		rand.Read(padding)
+		body.Write(padding)
+		offset += int64(len(padding))
+
+		body.Write(v.Data[v.PrefixLength:])
+		indices[i] = ArchiveIndex{v.FilePath, offset ^ int64(settings.key), len(v.Data) ^ settings.key, v.Data[:v.PrefixLength]}
+		offset += int64(len(v.Data) - v.PrefixLength)
+	}
+
+	// Compress the pickled file tree.
+	var tree bytes.Buffer
+	stream := zlib.NewWriter(&tree)
+	_, err := stream.Write(Pickle(indices))
+	if err != nil {
+		return err
+	}
+	err = stream.Close()
+	if err != nil {
+		return err
+	}
+
+	// Write header, bodies and file tree.
+	for _, v := range [][]byte{formatHeader(offset, settings.key), body.Bytes(), tree.Bytes()} {
+		_, err = writer.Write(v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Formats the header line of an RPA-3.0 archive.
+func formatHeader(offset int64, key int) []byte {
+	return []byte(fmt.Sprintf("RPA-3.0 %016x %08x\n", offset, uint32(key)))
+}
+
+// Serializes the archive indices as a protocol 2 pickle in the layout used by Ren'Py.
+// The file tree is a dictionary mapping every file path to a list containing a tuple of offset, length and prefix.
+// Equal prefixes are referenced through the memo, like Python does for the shared empty string.
+// The items are set in batches of 1000 like Python's pickler does, a batch of a single item uses SETITEM.
+func Pickle(indices []ArchiveIndex) []byte {
+	var buffer bytes.Buffer
+	memo := 0
+	put := func() {
+		if memo < 256 {
+			buffer.Write([]byte{binaryInput, byte(memo)})
+		} else {
+			buffer.WriteByte(longBinaryInput)
+			binary.Write(&buffer, binary.LittleEndian, int32(memo))
+		}
+		memo++
+	}
+
+	buffer.Write([]byte{0x80, 2, '}'})
+	put()
+	prefixes := make(map[string]int)
+	for i, v := range indices {
+		// Python writes the items of a dictionary in batches of 1000, a batch of a single item is set without a mark.
+		batch := len(indices) - i / 1000 * 1000
+		if batch > 1000 {
+			batch = 1000
+		}
+		if i % 1000 == 0 && batch > 1 {
+			buffer.WriteByte('(')
+		}
+
+		buffer.WriteByte(unicodeString)
+		binary.Write(&buffer, binary.LittleEndian, int32(len(v.FilePath)))
+		buffer.WriteString(v.FilePath)
+		put()
+		buffer.WriteByte(']')
+		put()
+		writePickleInteger(&buffer, v.Offset)
+		writePickleInteger(&buffer, int64(v.Length))
+		if position, ok := prefixes[string(v.Prefix)]; ok {
+			if position < 256 {
+				buffer.Write([]byte{binaryGet, byte(position)})
+			} else {
+				buffer.WriteByte(longBinaryGet)
+				binary.Write(&buffer, binary.LittleEndian, int32(position))
+			}
+		} else {
+			buffer.Write([]byte{shortBinaryString, byte(len(v.Prefix))})
+			buffer.Write(v.Prefix)
+			prefixes[string(v.Prefix)] = memo
+			put()
+		}
+		buffer.WriteByte(endIndexPrefix)
+		put()
+		buffer.WriteByte('a')
+
+		// Finish the batch.
+		if i % 1000 == batch - 1 {
+			if batch > 1 {
+				buffer.WriteByte('u')
+			} else {
+				buffer.WriteByte('s')
+			}
+		}
+	}
+	buffer.WriteByte('.')
+	return buffer.Bytes()
+}
+
+// Writes the integer using the smallest opcode a Python pickler would use for it.
+func writePickleInteger(buffer *bytes.Buffer, number int64) {
+	switch {
+	case number >= 0 && number < 1 << 8:
+		buffer.Write([]byte{binaryInteger1, byte(number)})
+	case number >= 0 && number < 1 << 16:
+		buffer.WriteByte(binaryInteger2)
+		binary.Write(buffer, binary.LittleEndian, uint16(number))
+	case number >= -1 << 31 && number < 1 << 31:
+		buffer.WriteByte(binaryInteger)
+		binary.Write(buffer, binary.LittleEndian, int32(number))
+	default:
+		// Store the number as little endian two's complement with as few bytes as possible.
+		encoded := make([]byte, 8)
+		binary.LittleEndian.PutUint64(encoded, uint64(number))
+		length := 8
+		for length > 1 && ((encoded[length - 1] == 0 && encoded[length - 2] < 0x80) || (encoded[length - 1] == 0xFF && encoded[length - 2] >= 0x80)) {
+			length--
+		}
+		buffer.Write([]byte{binaryLong, byte(length)})
+		buffer.Write(encoded[:length])
+	}
+}
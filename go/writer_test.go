@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Writes an archive containing the specified files into a temporary directory and returns its path.
+func writeTestArchive(t testing.TB, files []WriterFile, options ...WriterOption) string {
+	t.Helper()
+	var buffer bytes.Buffer
+	err := WriteArchive(&buffer, files, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "archive.rpa")
+	err = os.WriteFile(path, buffer.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Returns a set of files of different sizes with and without prefixes.
+func testFiles() []WriterFile {
+	return []WriterFile{
+		{FilePath: "images/a.png", Data: bytes.Repeat([]byte("PNGDATA"), 10)},
+		{FilePath: "images/sub/b.jpg", Data: bytes.Repeat([]byte("J"), 3000), PrefixLength: 3},
+		{FilePath: "script.rpyc", Data: []byte("SCRIPT")},
+		{FilePath: "audio/c.ogg", Data: []byte{}},
+		{FilePath: "large.bin", Data: bytes.Repeat([]byte{0x58, 0x00, 0xFF}, 30000)},
+	}
+}
+
+// Checks that every file of the archive contains exactly the written data.
+func assertArchiveContents(t *testing.T, archive *Archive, files []WriterFile) {
+	t.Helper()
+	if len(archive.Indices) != len(files) {
+		t.Fatalf("expected %d indices, got %d", len(files), len(archive.Indices))
+	}
+	for i, v := range files {
+		index := archive.Indices[i]
+		if index.FilePath != v.FilePath {
+			t.Fatalf("expected path %s, got %s", v.FilePath, index.FilePath)
+		}
+		data, err := archive.Read(&index)
+		if err != nil {
+			t.Fatalf("%s: %v", v.FilePath, err)
+		}
+		if !bytes.Equal(data, v.Data) {
+			t.Fatalf("%s: contents differ", v.FilePath)
+		}
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	files := testFiles()
+	archive, err := NewArchive(writeTestArchive(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	assertArchiveContents(t, archive, files)
+	if archive.countLargeGaps(maxPadding) != 0 {
+		t.Fatal("expected no large gaps without padding")
+	}
+}
+
+func TestWriteArchiveWithPadding(t *testing.T) {
+	for _, padding := range [][2]int{{0, 0}, {1, 17}, {64, 64}, {5000, 6000}} {
+		t.Run(fmt.Sprintf("%d-%d", padding[0], padding[1]), func(t *testing.T) {
+			files := testFiles()
+			archive, err := NewArchive(writeTestArchive(t, files, WithPadding(padding[0], padding[1])))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer archive.Close()
+
+			assertArchiveContents(t, archive, files)
+
+			// Every body is preceded by at least the minimum padding.
+			sorted := archive.indicesByOffset()
+			for i := 1; i < len(sorted); i++ {
+				previous := sorted[i - 1]
+				gap := sorted[i].Offset - (previous.Offset + int64(previous.Length - len(previous.Prefix)))
+				if gap < int64(padding[0]) || gap > int64(padding[1]) {
+					t.Fatalf("gap of %d bytes is outside of the padding range", gap)
+				}
+			}
+
+			gaps := archive.countLargeGaps(maxPadding)
+			if padding[0] > maxPadding && gaps != len(files) - 1 {
+				t.Fatalf("expected %d large gaps, got %d", len(files) - 1, gaps)
+			} else if padding[1] <= maxPadding && gaps != 0 {
+				t.Fatalf("expected no large gaps, got %d", gaps)
+			}
+		})
+	}
+}
+
+func TestWriteArchiveInvalidPadding(t *testing.T) {
+	for _, padding := range [][2]int{{-1, 5}, {10, 5}} {
+		err := WriteArchive(&bytes.Buffer{}, testFiles(), WithPadding(padding[0], padding[1]))
+		if err == nil {
+			t.Fatalf("expected error for padding range %v", padding)
+		}
+	}
+}
+
+func TestPickleSingleItem(t *testing.T) {
+	// Matches pickle.dumps({"a.png": [(0x10, 0x20, "")]}, 2) of Python 2, which sets a single item without a mark.
+	expected, _ := hex.DecodeString("80027d71005805000000612e706e6771015d71024b104b205500710387710461732e")
+	data := Pickle([]ArchiveIndex{{"a.png", 0x10, 0x20, []byte{}}})
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("expected %x, got %x", expected, data)
+	}
+
+	// An archive with a single file can be read back.
+	files := []WriterFile{{FilePath: "single.txt", Data: []byte("only file"), PrefixLength: 4}}
+	archive, err := NewArchive(writeTestArchive(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	assertArchiveContents(t, archive, files)
+}
+
+func TestPickleBatches(t *testing.T) {
+	tests := []struct {
+		count int
+		marks int
+		end string
+	}{
+		{0, 0, "q\x00."},
+		{2, 1, "au."},
+		{1000, 1, "au."},
+		{1001, 1, "as."},
+		{2002, 3, "au."},
+	}
+	for _, v := range tests {
+		indices := make([]ArchiveIndex, v.count)
+		for i := range indices {
+			indices[i] = ArchiveIndex{fmt.Sprintf("%04d.png", i), int64(i), 1, []byte{}}
+		}
+		data := Pickle(indices)
+
+		// Count the marks which start a batch before a file path.
+		marks := bytes.Count(data, []byte{'(', unicodeString})
+		if marks != v.marks || !bytes.HasSuffix(data, []byte(v.end)) {
+			t.Fatalf("%d indices: expected %d marks and suffix %q, got %d marks and %q", v.count, v.marks, v.end, marks, data[len(data) - 3:])
+		}
+
+		parsed, err := Unpickle(data)
+		if err != nil {
+			t.Fatalf("%d indices: %v", v.count, err)
+		}
+		if len(parsed) != v.count || (v.count > 0 && !reflect.DeepEqual(parsed, indices)) {
+			t.Fatalf("%d indices: got %d indices", v.count, len(parsed))
+		}
+	}
+}